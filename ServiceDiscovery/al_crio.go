@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+type CrioDiscoveryResult struct {
+	isDetected bool
+	properties map[string]interface{}
+	auth       *HttpAuthClassification
+}
+
+func (r *CrioDiscoveryResult) Protocol() string {
+	return "cri-o"
+}
+
+func (r *CrioDiscoveryResult) GetIsDetected() bool {
+	return r.isDetected
+}
+
+func (r *CrioDiscoveryResult) GetProperties() map[string]interface{} {
+	return r.properties
+}
+
+// GetIsAuthRequired is usually false, access to crio.sock is only guarded by
+// file permissions
+func (r *CrioDiscoveryResult) GetIsAuthRequired() bool {
+	return r.auth.IsAuthRequired()
+}
+
+type CrioDiscovery struct {
+}
+
+func (d *CrioDiscovery) Protocol() string {
+	return "cri-o"
+}
+
+func (d *CrioDiscovery) ProbeOrder() ProbeOrder {
+	return CLIENT_FIRST
+}
+
+// Discover identifies CRI-O from the /info endpoint it serves next to the CRI gRPC API
+func (d *CrioDiscovery) Discover(sessionHandler iSessionHandler, presentationLayerDiscoveryResult iPresentationDiscoveryResult) (iApplicationDiscoveryResult, error) {
+	resp, err := httpProbePath(sessionHandler, "/info")
+	if err != nil {
+		return nil, err
+	}
+
+	var info struct {
+		StorageDriver string `json:"storage_driver"`
+		StorageRoot   string `json:"storage_root"`
+		CgroupDriver  string `json:"cgroup_driver"`
+	}
+	if resp.StatusCode != 200 || json.Unmarshal(resp.Body, &info) != nil || info.StorageDriver == "" || info.CgroupDriver == "" {
+		return &CrioDiscoveryResult{isDetected: false}, nil
+	}
+
+	auth := httpClassifyAuth(sessionHandler, "/info")
+	return &CrioDiscoveryResult{
+		isDetected: true,
+		auth:       auth,
+		properties: map[string]interface{}{
+			"storageDriver": info.StorageDriver,
+			"storageRoot":   info.StorageRoot,
+			"cgroupDriver":  info.CgroupDriver,
+			"auth":          auth.Properties(),
+			"evidence":      httpPathEvidence(sessionHandler, "/info"),
+		},
+	}, nil
+}
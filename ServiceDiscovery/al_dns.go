@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DNS record types and classes used by the probe
+const (
+	dnsTypeTXT    = 16
+	dnsClassChaos = 3
+)
+
+type DnsDiscoveryResult struct {
+	isDetected bool
+	properties map[string]interface{}
+}
+
+func (r *DnsDiscoveryResult) Protocol() string {
+	return "dns"
+}
+
+func (r *DnsDiscoveryResult) GetIsDetected() bool {
+	return r.isDetected
+}
+
+func (r *DnsDiscoveryResult) GetProperties() map[string]interface{} {
+	return r.properties
+}
+
+func (r *DnsDiscoveryResult) GetIsAuthRequired() bool {
+	return false
+}
+
+type DnsDiscovery struct {
+}
+
+func (d *DnsDiscovery) Protocol() string {
+	return "dns"
+}
+
+func (d *DnsDiscovery) ProbeOrder() ProbeOrder {
+	return CLIENT_FIRST
+}
+
+// Discover asks for the CHAOS TXT record version.bind. Any well formed answer
+// carrying our query ID identifies a DNS server, even if it refuses the query.
+func (d *DnsDiscovery) Discover(sessionHandler iSessionHandler, presentationLayerDiscoveryResult iPresentationDiscoveryResult) (iApplicationDiscoveryResult, error) {
+	err := sessionHandler.Connect()
+	if err != nil {
+		return nil, err
+	}
+	defer sessionHandler.Destory()
+
+	id := make([]byte, 2)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	query := append([]byte{}, id...)
+	query = append(query, 0x01, 0x00) // standard query, recursion desired
+	query = append(query, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	query = append(query, dnsEncodeName("version.bind")...)
+	query = binary.BigEndian.AppendUint16(query, dnsTypeTXT)
+	query = binary.BigEndian.AppendUint16(query, dnsClassChaos)
+	if _, err := sessionHandler.Write(query); err != nil {
+		return nil, err
+	}
+
+	response := make([]byte, 4096)
+	sessionHandler.SetReadDeadline(time.Now().Add(udpProbeTimeout))
+	n, err := sessionHandler.Read(response)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		// UDP ports drop datagrams of other protocols, no answer is not an error
+		return &DnsDiscoveryResult{isDetected: false}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	response = response[:n]
+
+	// Header: ID, flags and the four section counts
+	if len(response) < 12 || response[0] != id[0] || response[1] != id[1] || response[2]&0x80 == 0 {
+		return &DnsDiscoveryResult{isDetected: false}, nil
+	}
+
+	properties := map[string]interface{}{
+		"rcode":              dnsRcodeName(response[3] & 0x0f),
+		"recursionAvailable": response[3]&0x80 != 0,
+		"authoritative":      response[2]&0x04 != 0,
+	}
+	if version, err := dnsFirstTxtAnswer(response); err == nil {
+		properties["version"] = version
+	}
+
+	return &DnsDiscoveryResult{
+		isDetected: true,
+		properties: properties,
+	}, nil
+}
+
+func dnsEncodeName(name string) []byte {
+	var encoded []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		encoded = append(encoded, byte(len(label)))
+		encoded = append(encoded, label...)
+	}
+	return append(encoded, 0x00)
+}
+
+// dnsSkipName returns the offset after a possibly compressed name
+func dnsSkipName(message []byte, offset int) (int, error) {
+	for offset < len(message) {
+		length := int(message[offset])
+		switch {
+		case length == 0:
+			return offset + 1, nil
+		case length&0xc0 == 0xc0:
+			return offset + 2, nil
+		}
+		offset += length + 1
+	}
+	return 0, errors.New("DNS name runs past the message")
+}
+
+// dnsFirstTxtAnswer extracts the first string of the first TXT answer
+func dnsFirstTxtAnswer(message []byte) (string, error) {
+	questions := int(binary.BigEndian.Uint16(message[4:6]))
+	answers := int(binary.BigEndian.Uint16(message[6:8]))
+
+	offset := 12
+	var err error
+	for i := 0; i < questions; i++ {
+		if offset, err = dnsSkipName(message, offset); err != nil {
+			return "", err
+		}
+		offset += 4
+	}
+
+	for i := 0; i < answers; i++ {
+		if offset, err = dnsSkipName(message, offset); err != nil {
+			return "", err
+		}
+		if offset+10 > len(message) {
+			return "", errors.New("DNS answer is truncated")
+		}
+		recordType := binary.BigEndian.Uint16(message[offset : offset+2])
+		length := int(binary.BigEndian.Uint16(message[offset+8 : offset+10]))
+		offset += 10
+		if offset+length > len(message) {
+			return "", errors.New("DNS answer is truncated")
+		}
+		if recordType == dnsTypeTXT && length > 0 {
+			txtLength := int(message[offset])
+			if 1+txtLength <= length {
+				return string(message[offset+1 : offset+1+txtLength]), nil
+			}
+		}
+		offset += length
+	}
+	return "", errors.New("no TXT answer")
+}
+
+func dnsRcodeName(rcode byte) string {
+	names := []string{"NOERROR", "FORMERR", "SERVFAIL", "NXDOMAIN", "NOTIMP", "REFUSED"}
+	if int(rcode) < len(names) {
+		return names[rcode]
+	}
+	return "RCODE" + strconv.Itoa(int(rcode))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+type DockerDiscoveryResult struct {
+	isDetected bool
+	properties map[string]interface{}
+	auth       *HttpAuthClassification
+}
+
+func (r *DockerDiscoveryResult) Protocol() string {
+	return "docker"
+}
+
+func (r *DockerDiscoveryResult) GetIsDetected() bool {
+	return r.isDetected
+}
+
+func (r *DockerDiscoveryResult) GetProperties() map[string]interface{} {
+	return r.properties
+}
+
+// GetIsAuthRequired is only true behind TLS client certificates or an
+// authenticating proxy, the Docker API has no authentication of its own
+func (r *DockerDiscoveryResult) GetIsAuthRequired() bool {
+	return r.auth.IsAuthRequired()
+}
+
+type DockerDiscovery struct {
+}
+
+func (d *DockerDiscovery) Protocol() string {
+	return "docker"
+}
+
+func (d *DockerDiscovery) ProbeOrder() ProbeOrder {
+	return CLIENT_FIRST
+}
+
+// Discover identifies the Docker Engine API (docker.sock or tcp/2375) from /version
+func (d *DockerDiscovery) Discover(sessionHandler iSessionHandler, presentationLayerDiscoveryResult iPresentationDiscoveryResult) (iApplicationDiscoveryResult, error) {
+	resp, err := httpProbePath(sessionHandler, "/version")
+	if err != nil {
+		return nil, err
+	}
+
+	var version struct {
+		Version       string
+		ApiVersion    string
+		Os            string
+		Arch          string
+		KernelVersion string
+	}
+	json.Unmarshal(resp.Body, &version)
+
+	if version.ApiVersion == "" && !strings.HasPrefix(resp.Header.Get("Server"), "Docker/") {
+		return &DockerDiscoveryResult{isDetected: false}, nil
+	}
+
+	auth := httpClassifyAuth(sessionHandler, "/version")
+	return &DockerDiscoveryResult{
+		isDetected: true,
+		auth:       auth,
+		properties: map[string]interface{}{
+			"version":       version.Version,
+			"apiVersion":    version.ApiVersion,
+			"os":            version.Os,
+			"arch":          version.Arch,
+			"kernelVersion": version.KernelVersion,
+			"reachable":     resp.StatusCode == 200,
+			"auth":          auth.Properties(),
+			"evidence":      httpPathEvidence(sessionHandler, "/version"),
+		},
+	}, nil
+}
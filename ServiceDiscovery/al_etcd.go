@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type EtcdDiscoveryResult struct {
+	isDetected   bool
+	properties   map[string]interface{}
+	authRequired bool
+}
+
+func (r *EtcdDiscoveryResult) Protocol() string {
+	return "etcd"
+}
+
+func (r *EtcdDiscoveryResult) GetIsDetected() bool {
+	return r.isDetected
+}
+
+func (r *EtcdDiscoveryResult) GetProperties() map[string]interface{} {
+	return r.properties
+}
+
+// GetIsAuthRequired tells whether etcd rejected an anonymous read through the
+// v2 or v3 API
+func (r *EtcdDiscoveryResult) GetIsAuthRequired() bool {
+	return r.authRequired
+}
+
+type EtcdDiscovery struct {
+}
+
+func (d *EtcdDiscovery) Protocol() string {
+	return "etcd"
+}
+
+func (d *EtcdDiscovery) ProbeOrder() ProbeOrder {
+	return CLIENT_FIRST
+}
+
+// gRPC methods of the etcd v3 API
+const (
+	etcdStatusPath = "/etcdserverpb.Maintenance/Status"
+	etcdRangePath  = "/etcdserverpb.KV/Range"
+)
+
+// EtcdCheckSecretEncryption enables reading one Kubernetes secret to check
+// whether secrets are encrypted at rest, it is set with -etcd-secrets
+var EtcdCheckSecretEncryption = false
+
+// etcdSecretsPrefix is where the kube-apiserver stores secrets with the default --etcd-prefix
+const etcdSecretsPrefix = "/registry/secrets/"
+
+// etcdSecretValuePrefixes map the start of a stored secret to the provider of
+// the kube-apiserver EncryptionConfiguration which wrote it
+var etcdSecretValuePrefixes = []struct {
+	prefix   string
+	provider string
+}{
+	{"k8s:enc:aescbc:v1:", "aescbc"},
+	{"k8s:enc:aesgcm:v1:", "aesgcm"},
+	{"k8s:enc:secretbox:v1:", "secretbox"},
+	{"k8s:enc:kms:v1:", "kms-v1"},
+	{"k8s:enc:kms:v2:", "kms-v2"},
+	// The identity provider stores the protobuf encoding, older clusters JSON
+	{"k8s\x00", "identity"},
+	{"{", "identity"},
+}
+
+// Discover identifies etcd from /version and checks anonymous reads through the
+// v2 HTTP API (/v2/keys) and the v3 gRPC API (KV.Range), which etcd serves on
+// the same port. Cluster, member and leader come from Maintenance.Status.
+func (d *EtcdDiscovery) Discover(sessionHandler iSessionHandler, presentationLayerDiscoveryResult iPresentationDiscoveryResult) (iApplicationDiscoveryResult, error) {
+	resp, err := httpProbePath(sessionHandler, "/version")
+	if err != nil {
+		return nil, err
+	}
+	var version struct {
+		Server  string `json:"etcdserver"`
+		Cluster string `json:"etcdcluster"`
+	}
+	if resp.StatusCode != 200 || json.Unmarshal(resp.Body, &version) != nil || version.Server == "" {
+		return &EtcdDiscoveryResult{isDetected: false}, nil
+	}
+
+	properties := map[string]interface{}{
+		"version":        version.Server,
+		"clusterVersion": version.Cluster,
+	}
+	// Reads are authenticated, not anonymous, only when etcd asked for the
+	// client certificate and got it (--client-cert-auth)
+	withClientCertificate := sessionClientCertificateSent(sessionHandler)
+	properties["clientCertificate"] = withClientCertificate
+
+	var findings []Finding
+	readable, authRequired := etcdV2Read(sessionHandler, properties)
+
+	conn, err := openHttp2Connection(sessionHandler)
+	if err != nil {
+		properties["v3Error"] = err.Error()
+	} else {
+		defer conn.Close()
+		etcdV3Status(conn, sessionHandler, properties)
+		v3Read, v3Rejected := etcdV3Read(conn, sessionHandler, properties)
+		readable = readable || v3Read
+		authRequired = authRequired || v3Rejected
+		if EtcdCheckSecretEncryption && readable {
+			if finding := etcdSecretEncryption(conn, sessionHandler, properties); finding != nil {
+				findings = append(findings, *finding)
+			}
+		}
+	}
+
+	anonymousRead := readable && !withClientCertificate
+	properties["anonymousRead"] = anonymousRead
+	properties["evidence"] = httpPathEvidence(sessionHandler, "/version", "/v2/keys")
+	if anonymousRead {
+		findings = append(findings, Finding{
+			Severity: SEVERITY_CRITICAL,
+			Title:    "etcd allows anonymous reads",
+			Detail:   "keys can be read without credentials, including Kubernetes secrets when etcd backs a cluster",
+		})
+	}
+	if len(findings) > 0 {
+		properties["findings"] = findings
+	}
+
+	return &EtcdDiscoveryResult{
+		isDetected:   true,
+		properties:   properties,
+		authRequired: authRequired && !anonymousRead,
+	}, nil
+}
+
+// etcdV2Read lists the root of the v2 keyspace. It returns whether the read
+// succeeded and whether it was rejected for missing credentials.
+func etcdV2Read(sessionHandler iSessionHandler, properties map[string]interface{}) (bool, bool) {
+	resp, err := httpProbePath(sessionHandler, "/v2/keys")
+	if err != nil {
+		return false, false
+	}
+	if clusterId := resp.Header.Get("X-Etcd-Cluster-Id"); clusterId != "" {
+		properties["clusterId"] = clusterId
+	}
+
+	switch resp.StatusCode {
+	case 200:
+		var keys struct {
+			Node struct {
+				Nodes []json.RawMessage `json:"nodes"`
+			} `json:"node"`
+		}
+		properties["v2"] = "enabled"
+		if json.Unmarshal(resp.Body, &keys) == nil {
+			properties["v2RootKeys"] = len(keys.Node.Nodes)
+		}
+		return true, false
+	case 401, 403:
+		properties["v2"] = "enabled"
+		return false, true
+	}
+	// etcd 3.4 and later serve no v2 API unless --enable-v2 is set
+	properties["v2"] = "disabled"
+	return false, false
+}
+
+// etcdV3Status calls Maintenance.Status, which etcd answers without credentials
+func etcdV3Status(conn *http2Connection, sessionHandler iSessionHandler, properties map[string]interface{}) {
+	response, err := conn.grpcCall(sessionHandler, etcdStatusPath, [][]byte{{}})
+	if err != nil {
+		properties["v3Error"] = err.Error()
+		return
+	}
+	properties["v3StatusCall"] = response.statusName()
+	if response.status() != 0 || len(response.messages) == 0 {
+		return
+	}
+
+	// StatusResponse: header (1), version (2), dbSize (3), leader (4), raftTerm (6), errors (8), isLearner (10)
+	status := response.messages[0]
+	header := protoBytesFields(status, 1)
+	if len(header) > 0 {
+		// ResponseHeader: cluster_id (1), member_id (2), revision (3)
+		memberId := protoVarintField(header[0], 2)
+		leader := protoVarintField(status, 4)
+		properties["clusterId"] = fmt.Sprintf("%x", protoVarintField(header[0], 1))
+		properties["memberId"] = fmt.Sprintf("%x", memberId)
+		properties["leaderId"] = fmt.Sprintf("%x", leader)
+		properties["isLeader"] = leader != 0 && leader == memberId
+		properties["revision"] = protoVarintField(header[0], 3)
+	}
+	if version := protoBytesFields(status, 2); len(version) > 0 {
+		properties["version"] = string(version[0])
+	}
+	properties["dbSize"] = protoVarintField(status, 3)
+	properties["raftTerm"] = protoVarintField(status, 6)
+	properties["isLearner"] = protoVarintField(status, 10) == 1
+	var statusErrors []string
+	for _, message := range protoBytesFields(status, 8) {
+		statusErrors = append(statusErrors, string(message))
+	}
+	if len(statusErrors) > 0 {
+		properties["errors"] = statusErrors
+	}
+}
+
+// etcdV3Read asks KV.Range for a single key of the whole keyspace, keys only.
+// It returns whether the read succeeded and whether it was rejected for missing
+// credentials. Only the number of keys is reported, never a key or value.
+func etcdV3Read(conn *http2Connection, sessionHandler iSessionHandler, properties map[string]interface{}) (bool, bool) {
+	// RangeRequest: key (1) and range_end (2) "\x00" select every key, limit (3), keys_only (8)
+	request := protoAppendBytes(nil, 1, []byte{0})
+	request = protoAppendBytes(request, 2, []byte{0})
+	request = protoAppendVarint(request, 3, 1)
+	request = protoAppendVarint(request, 8, 1)
+
+	response, err := conn.grpcCall(sessionHandler, etcdRangePath, [][]byte{request})
+	if err != nil {
+		properties["v3Error"] = err.Error()
+		return false, false
+	}
+	properties["v3RangeCall"] = response.statusName()
+	if message := response.headers["grpc-message"]; message != "" {
+		properties["v3RangeMessage"] = message
+	}
+
+	if response.status() == 0 {
+		if len(response.messages) > 0 {
+			// RangeResponse.count (4)
+			properties["keyCount"] = protoVarintField(response.messages[0], 4)
+		}
+		return true, false
+	}
+	// Without a token etcd answers "user name is empty" or "permission denied"
+	message := response.headers["grpc-message"]
+	rejected := strings.Contains(message, "user name is empty") || strings.Contains(message, "permission denied") ||
+		strings.Contains(message, "invalid auth token") || response.status() == 7 || response.status() == 16
+	return false, rejected
+}
+
+// etcdSecretEncryption reads the value of one key under /registry/secrets/ and
+// tells the encryption provider from its prefix. The value is dropped right
+// after, neither the key nor any of the secret data is reported. It returns a
+// critical finding when secrets are stored in plaintext.
+func etcdSecretEncryption(conn *http2Connection, sessionHandler iSessionHandler, properties map[string]interface{}) *Finding {
+	// range_end is the prefix with its last byte incremented, "/" becomes "0"
+	rangeEnd := []byte(etcdSecretsPrefix)
+	rangeEnd[len(rangeEnd)-1]++
+	request := protoAppendBytes(nil, 1, []byte(etcdSecretsPrefix))
+	request = protoAppendBytes(request, 2, rangeEnd)
+	request = protoAppendVarint(request, 3, 1)
+
+	response, err := conn.grpcCall(sessionHandler, etcdRangePath, [][]byte{request})
+	if err != nil {
+		properties["secretEncryption"] = "unknown: " + err.Error()
+		return nil
+	}
+	if response.status() != 0 || len(response.messages) == 0 {
+		properties["secretEncryption"] = "unknown: " + response.statusName() + " " + response.headers["grpc-message"]
+		return nil
+	}
+	// RangeResponse.kvs (2), KeyValue.value (5)
+	keyValues := protoBytesFields(response.messages[0], 2)
+	if len(keyValues) == 0 {
+		properties["secretEncryption"] = "unknown: no key under " + etcdSecretsPrefix
+		return nil
+	}
+	values := protoBytesFields(keyValues[0], 5)
+	if len(values) == 0 {
+		properties["secretEncryption"] = "unknown: empty value"
+		return nil
+	}
+
+	provider := "unknown"
+	for _, entry := range etcdSecretValuePrefixes {
+		if strings.HasPrefix(string(values[0]), entry.prefix) {
+			provider = entry.provider
+			break
+		}
+	}
+	properties["secretEncryption"] = provider
+	if provider != "identity" {
+		return nil
+	}
+	return &Finding{
+		Severity: SEVERITY_CRITICAL,
+		Title:    "secrets stored in plaintext",
+		Detail:   "Kubernetes secrets in etcd are not encrypted at rest, no EncryptionConfiguration provider wrote them",
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Control plane components serving /healthz and /metrics on ports of their own
+const (
+	KUBE_CONTROLLER_MANAGER = "kube-controller-manager"
+	KUBE_SCHEDULER          = "kube-scheduler"
+)
+
+// kubeComponentPorts are the secure ports of the components and the legacy
+// insecure ports removed in Kubernetes 1.23 (scheduler) and 1.24 (controller-manager)
+var kubeComponentPorts = map[string]struct {
+	secure   int
+	insecure int
+}{
+	KUBE_CONTROLLER_MANAGER: {10257, 10252},
+	KUBE_SCHEDULER:          {10259, 10251},
+}
+
+// kubeComponentSensitivePaths leak the component configuration and runtime
+// internals when they are served anonymously
+var kubeComponentSensitivePaths = []string{"/metrics", "/configz", "/debug/pprof/"}
+
+type KubeComponentDiscoveryResult struct {
+	isDetected bool
+	component  string
+	properties map[string]interface{}
+	auth       *HttpAuthClassification
+}
+
+// Protocol returns the name of the detected component
+func (r *KubeComponentDiscoveryResult) Protocol() string {
+	return r.component
+}
+
+func (r *KubeComponentDiscoveryResult) GetIsDetected() bool {
+	return r.isDetected
+}
+
+func (r *KubeComponentDiscoveryResult) GetProperties() map[string]interface{} {
+	return r.properties
+}
+
+// GetIsAuthRequired tells whether /metrics rejects anonymous requests
+func (r *KubeComponentDiscoveryResult) GetIsAuthRequired() bool {
+	return r.auth.IsAuthRequired()
+}
+
+// KubeComponentDiscovery detects one control plane component, the list holds
+// an entry per component
+type KubeComponentDiscovery struct {
+	component string
+}
+
+func (d *KubeComponentDiscovery) Protocol() string {
+	return d.component
+}
+
+func (d *KubeComponentDiscovery) ProbeOrder() ProbeOrder {
+	return CLIENT_FIRST
+}
+
+// Discover identifies the component from the CN of its serving certificate
+// ("kube-scheduler@<timestamp>"), from /metrics, or from /healthz on its legacy
+// insecure port. Without --tls-cert-file the component generates a certificate
+// for "localhost@<timestamp>" and default RBAC forbids anonymous /metrics, so
+// that certificate on its secure port together with a 403 Status identifies it
+// as well. /version is not a signal, system:public-info-viewer grants it, like
+// /healthz, /livez and /readyz, to unauthenticated users. It checks whether
+// /metrics, /configz and /debug/pprof/ are served anonymously.
+func (d *KubeComponentDiscovery) Discover(sessionHandler iSessionHandler, presenationLayerDiscoveryResult iPresentationDiscoveryResult) (iApplicationDiscoveryResult, error) {
+	healthz, err := httpProbePath(sessionHandler, "/healthz")
+	if err != nil {
+		return nil, err
+	}
+	if healthz.StatusCode != 200 || strings.TrimSpace(string(healthz.Body)) != "ok" {
+		return &KubeComponentDiscoveryResult{isDetected: false, component: d.component}, nil
+	}
+
+	evidence := []string{}
+	ports := kubeComponentPorts[d.component]
+	cert := sessionPeerCertificate(sessionHandler)
+	if cert != nil && (cert.Subject.CommonName == d.component || strings.HasPrefix(cert.Subject.CommonName, d.component+"@")) {
+		evidence = append(evidence, "serving certificate "+cert.Subject.CommonName)
+	}
+	if cert != nil && kubeGeneratedCertificate(cert, "localhost") && sessionHandler.GetPort() == ports.secure {
+		// The certificate is shared by every component, only the port tells them apart
+		if resp, err := httpProbePath(sessionHandler, "/metrics"); err == nil && resp.StatusCode == 403 {
+			if _, ok := kubeStatusOf(resp); ok {
+				evidence = append(evidence, "generated certificate "+cert.Subject.CommonName+" on port "+strconv.Itoa(ports.secure)+" and a 403 Status for /metrics")
+			}
+		}
+	}
+	version := ""
+	if metrics, err := httpProbePath(sessionHandler, "/metrics"); err == nil && metrics.StatusCode == 200 {
+		samples, _ := parseMetrics(string(metrics.Body))
+		component, metricsVersion, _ := metricsComponent(samples)
+		if component == d.component {
+			evidence = append(evidence, "/metrics exposes "+component+" metrics")
+			version = metricsVersion
+		}
+	}
+	plainHttp := cert == nil
+	insecure := plainHttp && sessionHandler.GetPort() == ports.insecure
+	if insecure {
+		evidence = append(evidence, "/healthz on the legacy insecure port")
+	}
+	if len(evidence) == 0 {
+		return &KubeComponentDiscoveryResult{isDetected: false, component: d.component}, nil
+	}
+
+	properties := map[string]interface{}{
+		"identifiedBy": evidence,
+		"insecurePort": insecure,
+		"plainHttp":    plainHttp,
+	}
+	if resp, err := httpProbePath(sessionHandler, "/version"); err == nil && resp.StatusCode == 200 {
+		var info kubeVersion
+		if json.Unmarshal(resp.Body, &info) == nil && info.GitVersion != "" {
+			version = info.GitVersion
+		}
+	}
+	if version != "" {
+		properties["version"] = version
+	}
+
+	var findings []Finding
+	anonymousAccess := make(map[string]interface{})
+	var exposed []string
+	for _, path := range kubeComponentSensitivePaths {
+		auth := httpClassifyAuth(sessionHandler, path)
+		anonymousAccess[path] = auth.Properties()
+		if auth.Category == HTTP_AUTH_OPEN && auth.StatusCode == 200 {
+			exposed = append(exposed, path)
+		}
+	}
+	// Paths served to the -cert client certificate are classified as authenticated
+	properties["clientCertificate"] = sessionClientCertificateSent(sessionHandler)
+	properties["anonymousAccess"] = anonymousAccess
+	properties["anonymousPaths"] = exposed
+
+	if insecure {
+		findings = append(findings, Finding{
+			Severity: SEVERITY_HIGH,
+			Title:    d.component + " serves its legacy insecure port",
+			Detail:   "plain HTTP without authentication on port " + strconv.Itoa(sessionHandler.GetPort()) + ", the legacy insecure port is " + strconv.Itoa(ports.insecure) + " and the secure port " + strconv.Itoa(ports.secure),
+		})
+	} else if len(exposed) > 0 {
+		severity := SEVERITY_MEDIUM
+		for _, path := range exposed {
+			if path != "/metrics" {
+				severity = SEVERITY_HIGH
+			}
+		}
+		detail := strings.Join(exposed, ", ")
+		if plainHttp {
+			// e.g. a port forward or a proxy terminating TLS in front of the secure port
+			detail += " over plain HTTP on port " + strconv.Itoa(sessionHandler.GetPort())
+		}
+		findings = append(findings, Finding{
+			Severity: severity,
+			Title:    d.component + " serves sensitive paths anonymously",
+			Detail:   detail,
+		})
+	}
+	if len(findings) > 0 {
+		properties["findings"] = findings
+	}
+	properties["evidence"] = httpPathEvidence(sessionHandler, append([]string{"/healthz", "/version"}, kubeComponentSensitivePaths...)...)
+
+	auth := httpClassifyAuth(sessionHandler, "/metrics")
+	return &KubeComponentDiscoveryResult{
+		isDetected: true,
+		component:  d.component,
+		properties: properties,
+		auth:       auth,
+	}, nil
+}
+
+// kubeGeneratedCertificate tells whether cert was generated by a component
+// started without a serving certificate, "<host>@<timestamp>" issued by itself
+// or by the "<host>-ca@<timestamp>" CA generated with it
+func kubeGeneratedCertificate(cert *x509.Certificate, host string) bool {
+	commonName := cert.Subject.CommonName
+	return strings.HasPrefix(commonName, host+"@") && strings.HasSuffix(cert.Issuer.CommonName, commonName[len(host):])
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestKubeComponentPublicInfoIsNotAFinding(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// system:public-info-viewer serves these paths to unauthenticated users
+		switch r.URL.Path {
+		case "/version":
+			w.Write([]byte(kubeVersionBody))
+		case "/healthz", "/livez", "/readyz":
+			w.Write([]byte("ok"))
+		default:
+			kubeForbidden(w, r)
+		}
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{selfSignedCertificate(t, "kube-scheduler@1700000000")}}
+	server.StartTLS()
+	defer server.Close()
+	sessionHandler := sharedHandlerFor(t, server.Listener.Addr().String())
+
+	result, err := (&KubeComponentDiscovery{component: KUBE_SCHEDULER}).Discover(sessionHandler, nil)
+	if err != nil || !result.GetIsDetected() {
+		t.Fatalf("detected=%v err=%v", result != nil && result.GetIsDetected(), err)
+	}
+	properties := result.GetProperties()
+	if properties["version"] != "v1.29.2" {
+		t.Errorf("version = %v, want v1.29.2", properties["version"])
+	}
+	if findings, ok := properties["findings"]; ok {
+		t.Errorf("anonymous /version reported as a finding: %v", findings)
+	}
+	if !result.GetIsAuthRequired() {
+		t.Error("/metrics answered with 403 but auth is not required")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+)
+
+// kubeProxyModes are the answers of /proxyMode on the metrics port
+var kubeProxyModes = []string{"iptables", "ipvs", "nftables", "kernelspace", "userspace"}
+
+type KubeProxyDiscoveryResult struct {
+	isDetected bool
+	properties map[string]interface{}
+	auth       *HttpAuthClassification
+}
+
+func (r *KubeProxyDiscoveryResult) Protocol() string {
+	return "kube-proxy"
+}
+
+func (r *KubeProxyDiscoveryResult) GetIsDetected() bool {
+	return r.isDetected
+}
+
+func (r *KubeProxyDiscoveryResult) GetProperties() map[string]interface{} {
+	return r.properties
+}
+
+// GetIsAuthRequired is always false in practice, kube-proxy has no
+// authentication on its metrics and health ports
+func (r *KubeProxyDiscoveryResult) GetIsAuthRequired() bool {
+	return r.auth.IsAuthRequired()
+}
+
+type KubeProxyDiscovery struct {
+}
+
+func (d *KubeProxyDiscovery) Protocol() string {
+	return "kube-proxy"
+}
+
+func (d *KubeProxyDiscovery) ProbeOrder() ProbeOrder {
+	return CLIENT_FIRST
+}
+
+// kubeProxyHealth is the /healthz response of the health port (10256)
+type kubeProxyHealth struct {
+	LastUpdated  string `json:"lastUpdated"`
+	CurrentTime  string `json:"currentTime"`
+	NodeEligible *bool  `json:"nodeEligible"`
+}
+
+// Discover identifies the kube-proxy health port (10256) from its /healthz JSON
+// and the metrics port (10249) from kubeproxy_* metrics and /proxyMode. The
+// metrics port should only listen on localhost, so an answer on another address
+// is flagged. The health port listens on all addresses by default for load
+// balancer health checks and is only reported.
+func (d *KubeProxyDiscovery) Discover(sessionHandler iSessionHandler, presenationLayerDiscoveryResult iPresentationDiscoveryResult) (iApplicationDiscoveryResult, error) {
+	healthz, err := httpProbePath(sessionHandler, "/healthz")
+	if err != nil {
+		return nil, err
+	}
+
+	properties := make(map[string]interface{})
+	evidence := []string{}
+	var roles []string
+
+	var health kubeProxyHealth
+	if healthz.StatusCode == 200 || healthz.StatusCode == 503 {
+		if json.Unmarshal(healthz.Body, &health) == nil && health.LastUpdated != "" && health.CurrentTime != "" {
+			evidence = append(evidence, "/healthz returned the proxier sync times")
+			roles = append(roles, "healthz")
+			properties["healthy"] = healthz.StatusCode == 200
+			properties["lastUpdated"] = health.LastUpdated
+			if health.NodeEligible != nil {
+				properties["nodeEligible"] = *health.NodeEligible
+			}
+		}
+	}
+
+	metricsPort := false
+	if metrics, err := httpProbePath(sessionHandler, "/metrics"); err == nil && metrics.StatusCode == 200 {
+		samples, _ := parseMetrics(string(metrics.Body))
+		if component, version, _ := metricsComponent(samples); component == "kube-proxy" {
+			evidence = append(evidence, "/metrics exposes kubeproxy_* metrics")
+			metricsPort = true
+			if version != "" {
+				properties["version"] = version
+			}
+		}
+	}
+	if proxyMode, err := httpProbePath(sessionHandler, "/proxyMode"); err == nil && proxyMode.StatusCode == 200 {
+		mode := strings.TrimSpace(string(proxyMode.Body))
+		for _, known := range kubeProxyModes {
+			if mode == known {
+				evidence = append(evidence, "/proxyMode returned "+mode)
+				properties["mode"] = mode
+				metricsPort = true
+			}
+		}
+	}
+	if metricsPort {
+		roles = append(roles, "metrics")
+	}
+	if len(evidence) == 0 {
+		return &KubeProxyDiscoveryResult{isDetected: false}, nil
+	}
+	properties["identifiedBy"] = evidence
+	properties["serves"] = roles
+
+	// /configz of the metrics port dumps the KubeProxyConfiguration
+	var exposed []string
+	for _, path := range []string{"/configz", "/metrics"} {
+		if auth := httpClassifyAuth(sessionHandler, path); auth.Category == HTTP_AUTH_OPEN && auth.StatusCode == 200 {
+			exposed = append(exposed, path)
+		}
+	}
+	properties["anonymousPaths"] = exposed
+
+	loopback := isLoopbackHost(sessionHandler)
+	properties["nonLoopbackExposure"] = !loopback
+	if !loopback && (metricsPort || len(exposed) > 0) {
+		detail := "bound to " + sessionHandler.GetHost() + " instead of localhost"
+		if len(exposed) > 0 {
+			detail += ", serving " + strings.Join(exposed, ", ") + " without authentication"
+		}
+		properties["findings"] = []Finding{{
+			Severity: SEVERITY_MEDIUM,
+			Title:    "kube-proxy " + strings.Join(roles, " and ") + " port is exposed on a non-loopback address",
+			Detail:   detail,
+		}}
+	} else if !loopback {
+		// --healthz-bind-address defaults to 0.0.0.0:10256, cloud load balancers
+		// probe it to find the nodes running endpoints of a service
+		properties["findings"] = []Finding{{
+			Severity: SEVERITY_INFO,
+			Title:    "kube-proxy health port answers on a non-loopback address",
+			Detail:   "bound to " + sessionHandler.GetHost() + ", the default so that load balancers can health check nodes for services with externalTrafficPolicy: Local",
+		}}
+	}
+	properties["evidence"] = httpPathEvidence(sessionHandler, "/healthz", "/metrics", "/proxyMode", "/configz")
+
+	auth := httpClassifyAuth(sessionHandler, "/metrics")
+	return &KubeProxyDiscoveryResult{
+		isDetected: true,
+		properties: properties,
+		auth:       auth,
+	}, nil
+}
+
+// isLoopbackHost tells whether the endpoint of the session is only reachable
+// from the same machine, a loopback address or a unix socket
+func isLoopbackHost(sessionHandler iSessionHandler) bool {
+	if _, ok := unwrapSessionHandler(sessionHandler).(*UnixSessionHandler); ok {
+		return true
+	}
+	host := sessionHandler.GetHost()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"fmt"
+	"encoding/json"
 	"regexp"
 	"strings"
 )
@@ -9,6 +9,7 @@ import (
 type KubeApiServerDiscoveryResult struct {
 	isDetected bool
 	properties map[string]interface{}
+	auth       *HttpAuthClassification
 }
 
 func (r *KubeApiServerDiscoveryResult) Protocol() string {
@@ -23,9 +24,9 @@ func (r *KubeApiServerDiscoveryResult) GetProperties() map[string]interface{} {
 	return r.properties
 }
 
+// GetIsAuthRequired tells whether /api rejects anonymous requests
 func (r *KubeApiServerDiscoveryResult) GetIsAuthRequired() bool {
-	// This is just an example. You would need to implement this method based on your requirements.
-	return false
+	return r.auth.IsAuthRequired()
 }
 
 type KubeApiServerDiscovery struct {
@@ -35,34 +36,242 @@ func (d *KubeApiServerDiscovery) Protocol() string {
 	return "kube-apiserver"
 }
 
+func (d *KubeApiServerDiscovery) ProbeOrder() ProbeOrder {
+	return CLIENT_FIRST
+}
+
+// kubeApiServerAnonymousPaths are requested anonymously to judge what the
+// apiserver serves without credentials
+var kubeApiServerAnonymousPaths = []string{"/api", "/apis", "/healthz", "/version"}
+
+const kubeSelfSubjectRulesReviewPath = "/apis/authorization.k8s.io/v1/selfsubjectrulesreviews"
+
+// kubeVersion is the response of /version
+type kubeVersion struct {
+	Major      string `json:"major"`
+	Minor      string `json:"minor"`
+	GitVersion string `json:"gitVersion"`
+	GitCommit  string `json:"gitCommit"`
+	BuildDate  string `json:"buildDate"`
+	GoVersion  string `json:"goVersion"`
+	Platform   string `json:"platform"`
+}
+
+// kubeApiVersions is the response of /api, serverAddressByClientCIDRs is only
+// set by the apiserver
+type kubeApiVersions struct {
+	Kind                       string            `json:"kind"`
+	Versions                   []string          `json:"versions"`
+	ServerAddressByClientCIDRs []json.RawMessage `json:"serverAddressByClientCIDRs"`
+}
+
+// kubeLivezEtcdCheck matches the etcd line of /livez?verbose, passing or failing
+var kubeLivezEtcdCheck = regexp.MustCompile(`(?m)^\[[+-]\]etcd (ok|failed)`)
+
+// kubeStatus is the Status object the apiserver answers errors with
+type kubeStatus struct {
+	Kind       string `json:"kind"`
+	ApiVersion string `json:"apiVersion"`
+	Message    string `json:"message"`
+	Reason     string `json:"reason"`
+	Code       int    `json:"code"`
+}
+
+// kubeRulesReview is the part of a SelfSubjectRulesReview response listing rules
+type kubeRulesReview struct {
+	Status struct {
+		ResourceRules []struct {
+			Verbs         []string `json:"verbs"`
+			ApiGroups     []string `json:"apiGroups"`
+			Resources     []string `json:"resources"`
+			ResourceNames []string `json:"resourceNames"`
+		} `json:"resourceRules"`
+		NonResourceRules []struct {
+			Verbs           []string `json:"verbs"`
+			NonResourceURLs []string `json:"nonResourceURLs"`
+		} `json:"nonResourceRules"`
+		Incomplete      bool   `json:"incomplete"`
+		EvaluationError string `json:"evaluationError"`
+	} `json:"status"`
+}
+
+// Discover confirms a kube-apiserver from /version together with a signal only
+// the apiserver sends: /openapi/v2 describing the Kubernetes API, the
+// APIVersions of /api, or the etcd check of /livez?verbose. Default RBAC only
+// serves the latter to anonymous requests. Controller-manager and scheduler
+// serve /version and answer with Status objects as well, so a Status object
+// only supports the detection. It checks which of /api, /apis, /healthz and /version are served
+// anonymously. When anonymous requests are authenticated, a
+// SelfSubjectRulesReview lists what system:anonymous can do.
 func (d *KubeApiServerDiscovery) Discover(sessionHandler iSessionHandler, presentationLayerDiscoveryResult iPresentationDiscoveryResult) (iApplicationDiscoveryResult, error) {
-	// Use HttpDiscovery implementation to send an HTTP request to the session handler
-	httpDiscovery := &HttpDiscovery{}
-	plResult, err := httpDiscovery.Discover(sessionHandler)
+	versionResp, err := httpProbePath(sessionHandler, "/version")
 	if err != nil {
-		return nil, fmt.Errorf("failed to discover kube-apiserver: %v", err)
+		return nil, err
+	}
+	var version kubeVersion
+	if versionResp.StatusCode != 200 || json.Unmarshal(versionResp.Body, &version) != nil || version.GitVersion == "" || version.Major == "" {
+		return &KubeApiServerDiscoveryResult{isDetected: false}, nil
+	}
+
+	evidence := []string{}
+	if openapi, err := httpProbePath(sessionHandler, "/openapi/v2"); err == nil && openapi.StatusCode == 200 && strings.Contains(string(openapi.Body), `"swagger"`) && strings.Contains(string(openapi.Body), `"title":"Kubernetes"`) {
+		evidence = append(evidence, "/openapi/v2 describes the Kubernetes API")
+	}
+	if api, err := httpProbePath(sessionHandler, "/api"); err == nil && api.StatusCode == 200 {
+		var apiVersions kubeApiVersions
+		if json.Unmarshal(api.Body, &apiVersions) == nil && apiVersions.Kind == "APIVersions" && apiVersions.ServerAddressByClientCIDRs != nil {
+			evidence = append(evidence, "/api returned the APIVersions "+strings.Join(apiVersions.Versions, ", "))
+		}
+	}
+	if livez, err := httpProbePath(sessionHandler, "/livez?verbose"); err == nil && (livez.StatusCode == 200 || livez.StatusCode == 500) && kubeLivezEtcdCheck.Match(livez.Body) {
+		evidence = append(evidence, "/livez?verbose lists the etcd check")
+	}
+	if len(evidence) == 0 {
+		return &KubeApiServerDiscoveryResult{isDetected: false}, nil
+	}
+	evidence = append([]string{"/version returned " + version.GitVersion}, evidence...)
+	for _, path := range []string{"/openapi/v2", "/api"} {
+		resp, err := httpProbePath(sessionHandler, path)
+		if err != nil {
+			continue
+		}
+		if status, ok := kubeStatusOf(resp); ok {
+			evidence = append(evidence, path+" answered with a Status object, reason "+status.Reason)
+			break
+		}
+	}
+
+	properties := map[string]interface{}{
+		"identifiedBy": evidence,
+		"version":      version.GitVersion,
+		"platform":     version.Platform,
+		"buildDate":    version.BuildDate,
+		"goVersion":    version.GoVersion,
 	}
 
-	// Check if the HTTP response contains the Kubernetes server header
-	kubeHeader := plResult.GetProperties()["header"].(string)
-	if !strings.Contains(kubeHeader, "Server: Kubernetes") {
-		return nil, nil
+	// 401 everywhere means anonymous auth is disabled, 403 means the request was
+	// authenticated as system:anonymous and denied by the authorizer. Requests
+	// carrying the -cert client certificate are not anonymous at all.
+	withClientCertificate := sessionClientCertificateSent(sessionHandler)
+	properties["clientCertificate"] = withClientCertificate
+	anonymousAccess := make(map[string]interface{})
+	anonymousAuth := false
+	var served []string
+	for _, path := range kubeApiServerAnonymousPaths {
+		auth := httpClassifyAuth(sessionHandler, path)
+		anonymousAccess[path] = auth.Properties()
+		if (auth.StatusCode == 200 || auth.StatusCode == 403) && !withClientCertificate {
+			anonymousAuth = true
+		}
+		if !auth.IsAuthRequired() && auth.StatusCode == 200 {
+			served = append(served, path)
+		}
 	}
+	properties["anonymousAuth"] = anonymousAuth
+	properties["anonymousAccess"] = anonymousAccess
+	properties["anonymousPaths"] = served
 
-	// Extract the Kubernetes API server version from the header
-	re := regexp.MustCompile(`Server: Kubernetes\/(\S+)`)
-	match := re.FindStringSubmatch(kubeHeader)
-	if len(match) < 2 {
-		return nil, fmt.Errorf("failed to extract Kubernetes API server version")
+	var findings []Finding
+	if anonymousAuth {
+		if finding := kubeAnonymousRules(sessionHandler, properties); finding != nil {
+			findings = append(findings, *finding)
+		}
+	}
+	if len(findings) > 0 {
+		properties["findings"] = findings
 	}
-	version := match[1]
 
-	// Return a discovery result indicating that a kube-apiserver instance was detected
+	paths := append([]string{"/openapi/v2", "/livez?verbose"}, kubeApiServerAnonymousPaths...)
+	properties["evidence"] = httpPathEvidence(sessionHandler, paths...)
+
+	auth := httpClassifyAuth(sessionHandler, "/api")
+	properties["auth"] = auth.Properties()
 	return &KubeApiServerDiscoveryResult{
 		isDetected: true,
-		properties: map[string]interface{}{
-			"version": version,
-			"header":  kubeHeader,
-		},
+		auth:       auth,
+		properties: properties,
 	}, nil
 }
+
+// kubeStatusOf decodes the apiserver Status object of a response
+func kubeStatusOf(resp *HttpResponse) (kubeStatus, bool) {
+	var status kubeStatus
+	ok := json.Unmarshal(resp.Body, &status) == nil && status.Kind == "Status" && status.ApiVersion == "v1"
+	return status, ok
+}
+
+// kubeAnonymousRules submits a SelfSubjectRulesReview for the default namespace
+// without credentials and reports the rules of system:anonymous. It returns a
+// finding when the anonymous user can reach any resource.
+func kubeAnonymousRules(sessionHandler iSessionHandler, properties map[string]interface{}) *Finding {
+	review := []byte(`{"apiVersion":"authorization.k8s.io/v1","kind":"SelfSubjectRulesReview","spec":{"namespace":"default"}}`)
+	resp, err := httpRequest(sessionHandler, "POST", kubeSelfSubjectRulesReviewPath, map[string]string{"Content-Type": "application/json"}, review)
+	if err != nil {
+		properties["anonymousRulesReview"] = err.Error()
+		return nil
+	}
+	if resp.StatusCode != 201 && resp.StatusCode != 200 {
+		// system:basic-user only grants the review to authenticated users
+		properties["anonymousRulesReview"] = resp.StatusLine
+		return nil
+	}
+
+	var rulesReview kubeRulesReview
+	if err := json.Unmarshal(resp.Body, &rulesReview); err != nil {
+		properties["anonymousRulesReview"] = err.Error()
+		return nil
+	}
+	properties["anonymousRulesReview"] = "created"
+	if rulesReview.Status.Incomplete {
+		properties["anonymousRulesIncomplete"] = rulesReview.Status.EvaluationError
+	}
+
+	var resourceRules []string
+	wildcard := false
+	for _, rule := range rulesReview.Status.ResourceRules {
+		groups := make([]string, len(rule.ApiGroups))
+		for i, group := range rule.ApiGroups {
+			groups[i] = group
+			if group == "" {
+				groups[i] = "core"
+			}
+		}
+		description := strings.Join(rule.Verbs, ",") + " " + strings.Join(rule.Resources, ",") + " [" + strings.Join(groups, ",") + "]"
+		if len(rule.ResourceNames) > 0 {
+			description += " names=" + strings.Join(rule.ResourceNames, ",")
+		}
+		resourceRules = append(resourceRules, description)
+		for _, value := range append(rule.Verbs, rule.Resources...) {
+			wildcard = wildcard || value == "*"
+		}
+	}
+	var nonResourceRules []string
+	for _, rule := range rulesReview.Status.NonResourceRules {
+		nonResourceRules = append(nonResourceRules, strings.Join(rule.Verbs, ",")+" "+strings.Join(rule.NonResourceURLs, ","))
+	}
+	properties["anonymousResourceRules"] = resourceRules
+	properties["anonymousNonResourceRules"] = nonResourceRules
+
+	if wildcard {
+		return &Finding{
+			Severity: SEVERITY_CRITICAL,
+			Title:    "system:anonymous has wildcard permissions on the Kubernetes API",
+			Detail:   strings.Join(resourceRules, "; "),
+		}
+	}
+	// The review itself is allowed through the self-subject rules
+	var reachable []string
+	for _, rule := range resourceRules {
+		if !strings.Contains(rule, "selfsubject") {
+			reachable = append(reachable, rule)
+		}
+	}
+	if len(reachable) == 0 {
+		return nil
+	}
+	return &Finding{
+		Severity: SEVERITY_HIGH,
+		Title:    "system:anonymous can reach Kubernetes API resources",
+		Detail:   strings.Join(reachable, "; "),
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// kubeForbidden answers like the delegated authorizer of every control plane
+// component does for system:anonymous
+func kubeForbidden(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(403)
+	w.Write([]byte(`{"kind":"Status","apiVersion":"v1","metadata":{},"status":"Failure","message":"forbidden: User \"system:anonymous\" cannot get path \"` + r.URL.Path + `\"","reason":"Forbidden","details":{},"code":403}`))
+}
+
+const kubeVersionBody = `{"major":"1","minor":"29","gitVersion":"v1.29.2","gitCommit":"4b8e819355d791d96b7e9d9efe4cbafae2311c88","buildDate":"2024-02-14T10:32:40Z","goVersion":"go1.21.7","platform":"linux/amd64"}`
+
+func TestKubeApiServerDiscovery(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    bool
+	}{
+		{
+			name: "controller-manager with default RBAC",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/version":
+					w.Write([]byte(kubeVersionBody))
+				case "/healthz", "/livez":
+					w.Write([]byte("ok"))
+				default:
+					kubeForbidden(w, r)
+				}
+			},
+			want: false,
+		},
+		{
+			name: "apiserver with default RBAC",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/version":
+					w.Write([]byte(kubeVersionBody))
+				case "/livez":
+					w.Write([]byte("[+]ping ok\n[+]log ok\n[+]etcd ok\n[+]poststarthook/start-apiextensions-controllers ok\nlivez check passed\n"))
+				default:
+					kubeForbidden(w, r)
+				}
+			},
+			want: true,
+		},
+		{
+			name: "apiserver serving /api",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/version":
+					w.Write([]byte(kubeVersionBody))
+				case "/api":
+					w.Write([]byte(`{"kind":"APIVersions","versions":["v1"],"serverAddressByClientCIDRs":[{"clientCIDR":"0.0.0.0/0","serverAddress":"10.0.0.1:6443"}]}`))
+				default:
+					kubeForbidden(w, r)
+				}
+			},
+			want: true,
+		},
+		{
+			name: "Status objects only",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				kubeForbidden(w, r)
+			},
+			want: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(test.handler)
+			defer server.Close()
+			sessionHandler := sharedHandlerFor(t, server.Listener.Addr().String())
+
+			result, err := (&KubeApiServerDiscovery{}).Discover(sessionHandler, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.GetIsDetected() != test.want {
+				t.Errorf("detected = %v, want %v, identifiedBy %v", result.GetIsDetected(), test.want, result.GetProperties()["identifiedBy"])
+			}
+		})
+	}
+}
+
+func TestKubeApiServerClientCertificateIsNotAnonymous(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			kubeForbidden(w, r)
+			return
+		}
+		switch r.URL.Path {
+		case "/version":
+			w.Write([]byte(kubeVersionBody))
+		case "/api":
+			w.Write([]byte(`{"kind":"APIVersions","versions":["v1"],"serverAddressByClientCIDRs":[]}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	server.StartTLS()
+	defer server.Close()
+	tlsClientCertificates = []tls.Certificate{selfSignedCertificate(t, "admin")}
+	defer func() { tlsClientCertificates = nil }()
+	sessionHandler := sharedHandlerFor(t, server.Listener.Addr().String())
+
+	result, err := (&KubeApiServerDiscovery{}).Discover(sessionHandler, nil)
+	if err != nil || !result.GetIsDetected() {
+		t.Fatalf("detected=%v err=%v", result != nil && result.GetIsDetected(), err)
+	}
+	properties := result.GetProperties()
+	if properties["clientCertificate"] != true {
+		t.Error("the client certificate was not sent")
+	}
+	if properties["anonymousAuth"] != false || len(properties["anonymousPaths"].([]string)) > 0 {
+		t.Errorf("certificate authenticated responses reported as anonymous: anonymousAuth=%v anonymousPaths=%v", properties["anonymousAuth"], properties["anonymousPaths"])
+	}
+}
@@ -1,6 +1,39 @@
 package main
 
+import (
+	"crypto/x509"
+	"encoding/json"
+	"strings"
+)
+
+// Kubelet authorization modes, unknown when anonymous requests are not authenticated
+const (
+	KUBELET_AUTHZ_ALWAYS_ALLOW = "AlwaysAllow"
+	KUBELET_AUTHZ_WEBHOOK      = "Webhook"
+	KUBELET_AUTHZ_UNKNOWN      = "unknown"
+)
+
 type KubeletDiscoveryResult struct {
+	isDetected bool
+	properties map[string]interface{}
+	auth       *HttpAuthClassification
+}
+
+func (r *KubeletDiscoveryResult) Protocol() string {
+	return "kubelet"
+}
+
+func (r *KubeletDiscoveryResult) GetIsDetected() bool {
+	return r.isDetected
+}
+
+func (r *KubeletDiscoveryResult) GetProperties() map[string]interface{} {
+	return r.properties
+}
+
+// GetIsAuthRequired tells whether anonymous requests to /pods are rejected
+func (r *KubeletDiscoveryResult) GetIsAuthRequired() bool {
+	return r.auth.IsAuthRequired()
 }
 
 type KubeletDiscovery struct {
@@ -10,6 +43,210 @@ func (d *KubeletDiscovery) Protocol() string {
 	return "kubelet"
 }
 
+func (d *KubeletDiscovery) ProbeOrder() ProbeOrder {
+	return CLIENT_FIRST
+}
+
+// kubeletConfigz is the part of the /configz response telling how requests are authorized
+type kubeletConfigz struct {
+	KubeletConfig struct {
+		Authentication struct {
+			Anonymous struct {
+				Enabled *bool `json:"enabled"`
+			} `json:"anonymous"`
+			Webhook struct {
+				Enabled *bool `json:"enabled"`
+			} `json:"webhook"`
+		} `json:"authentication"`
+		Authorization struct {
+			Mode string `json:"mode"`
+		} `json:"authorization"`
+	} `json:"kubeletconfig"`
+}
+
+// Discover identifies the authenticated kubelet API (10250) from /healthz, /pods,
+// /spec and its serving certificate. /healthz is authorized like every other
+// path, so a 401 or 403 is accepted once the other evidence confirms a kubelet.
+// Anonymous auth and the authorization mode are judged from the status codes of
+// /pods and /configz:
+//
+//	401 - anonymous auth is disabled
+//	403 - anonymous auth is enabled, the Webhook authorizer denies system:anonymous
+//	200 - anonymous auth is enabled and the AlwaysAllow authorizer lets it through
 func (d *KubeletDiscovery) Discover(sessionHandler iSessionHandler, presenationLayerDiscoveryResult iPresentationDiscoveryResult) (iApplicationDiscoveryResult, error) {
-	return nil, nil
+	// The authenticated kubelet API is only served over TLS, the read-only
+	// port is left to KubeletReadOnlyDiscovery
+	cert := sessionPeerCertificate(sessionHandler)
+	if cert == nil {
+		return &KubeletDiscoveryResult{isDetected: false}, nil
+	}
+
+	healthz, err := httpProbePath(sessionHandler, "/healthz")
+	if err != nil {
+		return nil, err
+	}
+	pods, err := httpProbePath(sessionHandler, "/pods")
+	if err != nil {
+		return nil, err
+	}
+	spec, _ := httpProbePath(sessionHandler, "/spec/")
+
+	// The serving certificate only names the node, /pods or /spec/ has to confirm it
+	evidence := []string{}
+	confirmed := false
+	nodeName := kubeletNodeName(cert)
+	if nodeName != "" {
+		evidence = append(evidence, "serving certificate "+cert.Subject.CommonName)
+	}
+	podsBody := string(pods.Body)
+	switch {
+	case pods.StatusCode == 200 && strings.Contains(podsBody, `"kind":"PodList"`):
+		evidence = append(evidence, "/pods returned a PodList")
+		confirmed = true
+	case pods.StatusCode == 403 && strings.Contains(podsBody, "subresource=proxy"):
+		// Forbidden (user=system:anonymous, verb=get, resource=nodes, subresource=proxy)
+		evidence = append(evidence, "/pods denied access to the nodes/proxy subresource")
+		confirmed = true
+	case pods.StatusCode == 401 && nodeName != "":
+		evidence = append(evidence, "/pods requires authentication")
+		confirmed = true
+	}
+	if spec != nil && spec.StatusCode == 200 && strings.Contains(string(spec.Body), "num_cores") {
+		evidence = append(evidence, "/spec/ returned machine info")
+		confirmed = true
+	}
+
+	// The auth filter of the kubelet also covers /healthz, so a kubelet without
+	// anonymous auth (401) or with the Webhook authorizer (403) rejects it too
+	switch {
+	case !confirmed:
+		return &KubeletDiscoveryResult{isDetected: false}, nil
+	case healthz.StatusCode == 200 && strings.TrimSpace(string(healthz.Body)) == "ok":
+	case healthz.StatusCode == 401 || healthz.StatusCode == 403:
+		evidence = append(evidence, "/healthz requires authorization")
+	default:
+		return &KubeletDiscoveryResult{isDetected: false}, nil
+	}
+
+	auth := httpClassifyAuth(sessionHandler, "/pods")
+	properties := map[string]interface{}{
+		"identifiedBy": evidence,
+		"auth":         auth.Properties(),
+	}
+	if nodeName != "" {
+		properties["nodeName"] = nodeName
+	}
+	if server := pods.Header.Get("Server"); server != "" {
+		properties["server"] = server
+	}
+
+	// Requests carrying the -cert client certificate are authenticated, their
+	// status codes tell nothing about anonymous access
+	withClientCertificate := sessionClientCertificateSent(sessionHandler)
+	properties["clientCertificate"] = withClientCertificate
+	anonymous, authorization := kubeletAccess(sessionHandler, pods.StatusCode, withClientCertificate)
+	properties["anonymousAuth"] = anonymous
+	properties["authorizationMode"] = authorization
+
+	if pods.StatusCode == 200 {
+		var podList struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		if json.Unmarshal(pods.Body, &podList) == nil {
+			properties["podCount"] = len(podList.Items)
+		}
+	}
+	if version := kubeletVersion(sessionHandler); version != "" {
+		properties["version"] = version
+	}
+
+	namespace, pod, container, fromPodList := kubeletProbeTarget(pods)
+	access := kubeletProbeStreaming(sessionHandler, namespace, pod, container)
+	properties["streamingTarget"] = map[string]interface{}{
+		"namespace":   namespace,
+		"pod":         pod,
+		"container":   container,
+		"fromPodList": fromPodList,
+	}
+	properties["streamingAccess"] = access
+	if finding := kubeletStreamingFinding(access); finding != nil && !withClientCertificate {
+		properties["findings"] = []Finding{*finding}
+	}
+	properties["evidence"] = httpPathEvidence(sessionHandler, "/healthz", "/pods", "/spec/", "/configz", "/metrics")
+
+	return &KubeletDiscoveryResult{
+		isDetected: true,
+		properties: properties,
+		auth:       auth,
+	}, nil
+}
+
+// kubeletNodeName extracts the node name from the kubelet serving certificate,
+// which is either self-signed as "<node>@<timestamp>" or issued by the cluster
+// CA as "system:node:<node>"
+func kubeletNodeName(cert *x509.Certificate) string {
+	commonName := cert.Subject.CommonName
+	if strings.HasPrefix(commonName, "system:node:") {
+		return strings.TrimPrefix(commonName, "system:node:")
+	}
+	if index := strings.LastIndex(commonName, "@"); index > 0 && strings.HasSuffix(cert.Issuer.CommonName, commonName[index:]) {
+		return commonName[:index]
+	}
+	return ""
+}
+
+// kubeletAccess judges anonymous auth and the authorization mode from the
+// status of /pods, and from /configz which reports both settings when readable.
+// A /pods status answering our client certificate only tells the authorizer.
+func kubeletAccess(sessionHandler iSessionHandler, podsStatus int, withClientCertificate bool) (interface{}, string) {
+	var anonymous interface{} = "unknown"
+	authorization := KUBELET_AUTHZ_UNKNOWN
+	switch {
+	case withClientCertificate && podsStatus == 403:
+		// Only the authorizer denies an authenticated user
+		authorization = KUBELET_AUTHZ_WEBHOOK
+	case withClientCertificate:
+	case podsStatus == 401:
+		anonymous = false
+	case podsStatus == 403:
+		anonymous = true
+		authorization = KUBELET_AUTHZ_WEBHOOK
+	case podsStatus == 200:
+		anonymous = true
+		authorization = KUBELET_AUTHZ_ALWAYS_ALLOW
+	}
+
+	configz, err := httpProbePath(sessionHandler, "/configz")
+	if err != nil {
+		return anonymous, authorization
+	}
+	switch configz.StatusCode {
+	case 200:
+		var config kubeletConfigz
+		if json.Unmarshal(configz.Body, &config) == nil {
+			if enabled := config.KubeletConfig.Authentication.Anonymous.Enabled; enabled != nil {
+				anonymous = *enabled
+			}
+			if mode := config.KubeletConfig.Authorization.Mode; mode != "" {
+				authorization = mode
+			}
+		}
+	case 403:
+		if authorization == KUBELET_AUTHZ_UNKNOWN {
+			authorization = KUBELET_AUTHZ_WEBHOOK
+		}
+	}
+	return anonymous, authorization
+}
+
+// kubeletVersion reads the kubelet version from kubernetes_build_info when
+// /metrics is readable
+func kubeletVersion(sessionHandler iSessionHandler) string {
+	metrics, err := httpProbePath(sessionHandler, "/metrics")
+	if err != nil || metrics.StatusCode != 200 {
+		return ""
+	}
+	samples, _ := parseMetrics(string(metrics.Body))
+	_, version, _ := metricsComponent(samples)
+	return version
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+type KubeletReadOnlyDiscoveryResult struct {
+	isDetected bool
+	properties map[string]interface{}
+	auth       *HttpAuthClassification
+}
+
+func (r *KubeletReadOnlyDiscoveryResult) Protocol() string {
+	return "kubelet-readonly"
+}
+
+func (r *KubeletReadOnlyDiscoveryResult) GetIsDetected() bool {
+	return r.isDetected
+}
+
+func (r *KubeletReadOnlyDiscoveryResult) GetProperties() map[string]interface{} {
+	return r.properties
+}
+
+// GetIsAuthRequired is false whenever the pod list was served, the read-only
+// port has no authentication at all
+func (r *KubeletReadOnlyDiscoveryResult) GetIsAuthRequired() bool {
+	return r.auth.IsAuthRequired()
+}
+
+type KubeletReadOnlyDiscovery struct {
+}
+
+func (d *KubeletReadOnlyDiscovery) Protocol() string {
+	return "kubelet-readonly"
+}
+
+func (d *KubeletReadOnlyDiscovery) ProbeOrder() ProbeOrder {
+	return CLIENT_FIRST
+}
+
+// kubeletContainer is the part of a container spec the exposure report reads.
+// Env only decodes the variable names, so values never leave the response body.
+type kubeletContainer struct {
+	Name  string `json:"name"`
+	Image string `json:"image"`
+	Env   []struct {
+		Name string `json:"name"`
+	} `json:"env"`
+}
+
+// kubeletPod is the part of a pod returned by the kubelet /pods endpoint
+type kubeletPod struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+		UID       string `json:"uid"`
+	} `json:"metadata"`
+	Spec struct {
+		NodeName       string             `json:"nodeName"`
+		Containers     []kubeletContainer `json:"containers"`
+		InitContainers []kubeletContainer `json:"initContainers"`
+	} `json:"spec"`
+	Status struct {
+		Phase string `json:"phase"`
+	} `json:"status"`
+}
+
+type kubeletPodList struct {
+	Kind  string       `json:"kind"`
+	Items []kubeletPod `json:"items"`
+}
+
+// Discover identifies the read-only kubelet port (10255), which serves /healthz
+// and /pods over plain HTTP without authentication, and summarises the workload
+// inventory it leaks
+func (d *KubeletReadOnlyDiscovery) Discover(sessionHandler iSessionHandler, presenationLayerDiscoveryResult iPresentationDiscoveryResult) (iApplicationDiscoveryResult, error) {
+	// The authenticated API on 10250 is left to KubeletDiscovery
+	if _, ok := unwrapSessionHandler(sessionHandler).(*TlsSessionHandler); ok {
+		return &KubeletReadOnlyDiscoveryResult{isDetected: false}, nil
+	}
+
+	healthz, err := httpProbePath(sessionHandler, "/healthz")
+	if err != nil {
+		return nil, err
+	}
+	if healthz.StatusCode != 200 || strings.TrimSpace(string(healthz.Body)) != "ok" {
+		return &KubeletReadOnlyDiscoveryResult{isDetected: false}, nil
+	}
+	pods, err := httpProbePath(sessionHandler, "/pods")
+	if err != nil {
+		return nil, err
+	}
+	var podList kubeletPodList
+	if pods.StatusCode != 200 || json.Unmarshal(pods.Body, &podList) != nil || podList.Kind != "PodList" {
+		return &KubeletReadOnlyDiscoveryResult{isDetected: false}, nil
+	}
+
+	auth := httpClassifyAuth(sessionHandler, "/pods")
+	properties := kubeletExposure(podList)
+	properties["unauthenticated"] = !auth.IsAuthRequired()
+	properties["auth"] = auth.Properties()
+	if version := kubeletVersion(sessionHandler); version != "" {
+		properties["version"] = version
+	}
+	properties["evidence"] = httpPathEvidence(sessionHandler, "/healthz", "/pods", "/metrics")
+
+	return &KubeletReadOnlyDiscoveryResult{
+		isDetected: true,
+		properties: properties,
+		auth:       auth,
+	}, nil
+}
+
+// kubeletExposure summarises a pod list: pod count, namespaces, images and the
+// names of environment variables per container. Variable values are redacted.
+func kubeletExposure(podList kubeletPodList) map[string]interface{} {
+	namespaces := make(map[string]bool)
+	images := make(map[string]bool)
+	envVars := make(map[string][]string)
+	nodeName := ""
+
+	for _, pod := range podList.Items {
+		namespaces[pod.Metadata.Namespace] = true
+		if pod.Spec.NodeName != "" {
+			nodeName = pod.Spec.NodeName
+		}
+		for _, container := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+			if container.Image != "" {
+				images[container.Image] = true
+			}
+			var names []string
+			for _, env := range container.Env {
+				names = append(names, env.Name+"=<redacted>")
+			}
+			if len(names) > 0 {
+				envVars[pod.Metadata.Namespace+"/"+pod.Metadata.Name+"/"+container.Name] = names
+			}
+		}
+	}
+
+	properties := map[string]interface{}{
+		"podCount":   len(podList.Items),
+		"namespaces": sortedKeys(namespaces),
+		"images":     sortedKeys(images),
+		"envVars":    envVars,
+	}
+	if nodeName != "" {
+		properties["nodeName"] = nodeName
+	}
+	return properties
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// kubeletProbePod names a pod which does not exist, /run is probed with it so
+// an authorized request ends at the pod lookup instead of running a command
+const kubeletProbePod = "kubescanner-probe"
+
+// KubeletEndpointAccess is the authorization outcome of one kubelet endpoint
+type KubeletEndpointAccess struct {
+	Endpoint   string
+	Path       string
+	StatusCode int
+	Allowed    bool
+}
+
+// kubeletProbeTarget picks a running pod and its first container from the /pods
+// response. When the pod list is not readable a made-up pod is returned, the
+// authorization check happens before the pod is looked up.
+func kubeletProbeTarget(pods *HttpResponse) (string, string, string, bool) {
+	var podList kubeletPodList
+	if pods != nil && pods.StatusCode == 200 && json.Unmarshal(pods.Body, &podList) == nil {
+		for _, pod := range podList.Items {
+			if pod.Status.Phase == "Running" && len(pod.Spec.Containers) > 0 {
+				return pod.Metadata.Namespace, pod.Metadata.Name, pod.Spec.Containers[0].Name, true
+			}
+		}
+	}
+	return "default", kubeletProbePod, "probe", false
+}
+
+// kubeletProbeStreaming checks whether the kubelet authorizes /exec, /run,
+// /attach, /portforward and /containerLogs for the pod. None of the requests
+// can execute anything:
+//
+//	/exec, /attach - no stdin, stdout or stderr is requested, an authorized
+//	                 request is rejected with 400 before the runtime is called
+//	/run           - posted for a pod which does not exist, an authorized
+//	                 request ends with 404 "pod does not exist"
+//	/portforward   - a WebSocket upgrade closed right away without a port
+//	/containerLogs - asks for no log lines, the body is discarded
+func kubeletProbeStreaming(sessionHandler iSessionHandler, namespace string, pod string, container string) []KubeletEndpointAccess {
+	var access []KubeletEndpointAccess
+
+	for _, endpoint := range []string{"exec", "attach"} {
+		path := "/" + endpoint + "/" + namespace + "/" + pod + "/" + container
+		capability := probeWebsocketUpgrade(sessionHandler, path, kubeWebsocketProtocols)
+		access = append(access, kubeletEndpointAccess(endpoint, path, capability.StatusCode, ""))
+	}
+
+	path := "/run/" + namespace + "/" + kubeletProbePod + "/" + container
+	resp, err := httpRequest(sessionHandler, "POST", path, nil, nil)
+	if err == nil {
+		access = append(access, kubeletEndpointAccess("run", path, resp.StatusCode, string(resp.Body)))
+	} else {
+		access = append(access, kubeletEndpointAccess("run", path, 0, ""))
+	}
+
+	path = "/portforward/" + namespace + "/" + pod
+	capability := probeWebsocketUpgrade(sessionHandler, path, []string{"v4.channel.k8s.io", "portforward.k8s.io"})
+	access = append(access, kubeletEndpointAccess("portforward", path, capability.StatusCode, ""))
+
+	path = "/containerLogs/" + namespace + "/" + pod + "/" + container
+	resp, err = httpGet(sessionHandler, path+"?tailLines=0&limitBytes=1")
+	if err == nil {
+		access = append(access, kubeletEndpointAccess("containerLogs", path, resp.StatusCode, string(resp.Body)))
+	} else {
+		access = append(access, kubeletEndpointAccess("containerLogs", path, 0, ""))
+	}
+	return access
+}
+
+// kubeletEndpointAccess judges a response of the authorization probe. 401 and
+// 403 come from the kubelet auth filter, 404 and 405 from a kubelet without
+// debugging handlers, every other response was produced after authorization.
+func kubeletEndpointAccess(endpoint string, path string, statusCode int, body string) KubeletEndpointAccess {
+	access := KubeletEndpointAccess{Endpoint: endpoint, Path: path, StatusCode: statusCode}
+	switch statusCode {
+	case 0, 401, 403, 405:
+		access.Allowed = false
+	case 404:
+		access.Allowed = strings.Contains(body, "pod does not exist")
+	default:
+		access.Allowed = true
+	}
+	return access
+}
+
+// kubeletStreamingFinding returns a critical finding when any of the probed
+// endpoints is authorized for an anonymous client
+func kubeletStreamingFinding(access []KubeletEndpointAccess) *Finding {
+	var allowed []string
+	execution := false
+	for _, endpoint := range access {
+		if !endpoint.Allowed {
+			continue
+		}
+		allowed = append(allowed, "/"+endpoint.Endpoint)
+		if endpoint.Endpoint == "exec" || endpoint.Endpoint == "run" || endpoint.Endpoint == "attach" {
+			execution = true
+		}
+	}
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	title := "kubelet streaming endpoints are open to anonymous clients"
+	if execution {
+		title = "kubelet allows anonymous command execution in containers"
+	}
+	return &Finding{
+		Severity: SEVERITY_CRITICAL,
+		Title:    title,
+		Detail:   "authorized without credentials: " + strings.Join(allowed, ", "),
+	}
+}
@@ -6,10 +6,6 @@ type ApplicationDiscoveryListItem struct {
 }
 
 var ApplicationDiscoveryList = []ApplicationDiscoveryListItem{
-	/*{
-		Discovery:  &KubeletDiscovery{},
-		Reqirement: string(HTTP),
-	},
 	{
 		Discovery:  &MysqlDiscovery{},
 		Reqirement: string(TCP),
@@ -18,12 +14,64 @@ var ApplicationDiscoveryList = []ApplicationDiscoveryListItem{
 		Discovery:  &RedisDiscovery{},
 		Reqirement: string(TCP),
 	},
-	{
-		Discovery:  &KafkaDiscovery{},
-		Reqirement: string(TCP),
-	},*/
 	{
 		Discovery:  &PostgresDiscovery{},
 		Reqirement: string(TCP),
 	},
+	{
+		Discovery:  &DnsDiscovery{},
+		Reqirement: string(UDP),
+	},
+	{
+		Discovery:  &NtpDiscovery{},
+		Reqirement: string(UDP),
+	},
+	{
+		Discovery:  &OverlayDiscovery{},
+		Reqirement: string(UDP),
+	},
+	{
+		Discovery:  &DockerDiscovery{},
+		Reqirement: string(HTTP),
+	},
+	{
+		Discovery:  &CrioDiscovery{},
+		Reqirement: string(HTTP),
+	},
+	{
+		Discovery:  &PrometheusMetricsDiscovery{},
+		Reqirement: string(HTTP),
+	},
+	{
+		Discovery:  &WebUiDiscovery{},
+		Reqirement: string(HTTP),
+	},
+	{
+		Discovery:  &KubeletDiscovery{},
+		Reqirement: string(HTTP),
+	},
+	{
+		Discovery:  &KubeletReadOnlyDiscovery{},
+		Reqirement: string(HTTP),
+	},
+	{
+		Discovery:  &EtcdDiscovery{},
+		Reqirement: string(HTTP),
+	},
+	{
+		Discovery:  &KubeApiServerDiscovery{},
+		Reqirement: string(HTTP),
+	},
+	{
+		Discovery:  &KubeComponentDiscovery{component: KUBE_CONTROLLER_MANAGER},
+		Reqirement: string(HTTP),
+	},
+	{
+		Discovery:  &KubeComponentDiscovery{component: KUBE_SCHEDULER},
+		Reqirement: string(HTTP),
+	},
+	{
+		Discovery:  &KubeProxyDiscovery{},
+		Reqirement: string(HTTP),
+	},
 }
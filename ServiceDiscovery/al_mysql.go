@@ -3,8 +3,12 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 )
 
+// mysqlProtocolVersion is the protocol version of the initial handshake of MySQL 3.21 and later
+const mysqlProtocolVersion = 10
+
 type MysqlDiscoveryResult struct {
 	IsDetected bool
 	properties map[string]interface{}
@@ -32,17 +36,30 @@ func (d *MysqlDiscovery) Protocol() string {
 	return "my-sql"
 }
 
+// ProbeOrder is server-first, the MySQL server sends its handshake before the client speaks
+func (d *MysqlDiscovery) ProbeOrder() ProbeOrder {
+	return SERVER_FIRST
+}
+
 func (d *MysqlDiscovery) Discover(sessionHandler iSessionHandler, presentationLayerDiscoveryResult iPresentationDiscoveryResult) (iApplicationDiscoveryResult, error) {
 	err := sessionHandler.Connect()
 	if err != nil {
 		return nil, err
 	}
+	defer sessionHandler.Destory()
 
 	// Decode initial handshake packet
 	packet := &InitialHandshakePacket{}
 	err = packet.Decode(sessionHandler)
-	if err != nil {
-		return nil, err
+	if err != nil || packet.ProtocolVersion != mysqlProtocolVersion {
+		// Other banner protocols (SSH, FTP, SMTP) share the passive phase
+		return &MysqlDiscoveryResult{IsDetected: false}, nil
+	}
+
+	// Report whether the server offers the SSL upgrade when running over plain TCP
+	tlsMode := sessionTlsMode(sessionHandler)
+	if tlsMode == TLS_MODE_UNAVAILABLE && packet.CapabilityFlags&mysqlClientSSL != 0 {
+		tlsMode = TLS_MODE_OFFERED
 	}
 
 	// Create MySQL discovery result
@@ -52,6 +69,7 @@ func (d *MysqlDiscovery) Discover(sessionHandler iSessionHandler, presentationLa
 			"ServerVersion":   packet.ServerVersion,
 			"protocolVersion": packet.ProtocolVersion,
 			"ConnectionId":    packet.ConnectionId,
+			"tls":             tlsMode,
 		},
 	}, nil
 }
@@ -67,15 +85,24 @@ type InitialHandshakePacket struct {
 	ProtocolVersion uint8
 	ServerVersion   []byte
 	ConnectionId    uint32
+	CapabilityFlags uint32
 	header          *PacketHeader
 }
 
 func (r *InitialHandshakePacket) Decode(sessionHandler iSessionHandler) error {
 	data := make([]byte, 1024)
-	_, err := sessionHandler.Read(data)
+	n, err := sessionHandler.Read(data)
 	if err != nil {
 		return err
 	}
+	return r.DecodeBytes(data[:n])
+}
+
+// DecodeBytes decodes the initial handshake packet from an already read greeting
+func (r *InitialHandshakePacket) DecodeBytes(data []byte) error {
+	if len(data) < 4 {
+		return errors.New("MySQL handshake packet is too short")
+	}
 
 	header := &PacketHeader{}
 	ln := []byte{data[0], data[1], data[2], 0x00}
@@ -85,6 +112,10 @@ func (r *InitialHandshakePacket) Decode(sessionHandler iSessionHandler) error {
 
 	r.header = header
 
+	if int(header.Length)+4 > len(data) || header.Length < 1 {
+		return errors.New("MySQL handshake packet is truncated")
+	}
+
 	// Assign payload only data to new var just for convenience
 	payload := data[4 : header.Length+4]
 	position := 0
@@ -96,6 +127,9 @@ func (r *InitialHandshakePacket) Decode(sessionHandler iSessionHandler) error {
 
 	// Extract server version
 	index := bytes.IndexByte(payload, byte(0x00))
+	if index < 0 || index+5 > len(payload) {
+		return errors.New("MySQL handshake packet is malformed")
+	}
 	r.ServerVersion = payload[position:index]
 	position = index + 1
 
@@ -104,6 +138,18 @@ func (r *InitialHandshakePacket) Decode(sessionHandler iSessionHandler) error {
 	id := binary.LittleEndian.Uint32(connectionId)
 	r.ConnectionId = id
 	position += 4
+
+	// Skip auth-plugin-data-part-1 and the filler, the lower capability flags follow
+	position += 8 + 1
+	if position+2 <= len(payload) {
+		r.CapabilityFlags = uint32(binary.LittleEndian.Uint16(payload[position : position+2]))
+		position += 2
+	}
+	// Character set and status flags precede the upper capability flags
+	position += 1 + 2
+	if position+2 <= len(payload) {
+		r.CapabilityFlags |= uint32(binary.LittleEndian.Uint16(payload[position:position+2])) << 16
+	}
 	// Return nil error since there is no error
 	return nil
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+// ntpPacketLength is the size of an NTP header without extensions
+const ntpPacketLength = 48
+
+type NtpDiscoveryResult struct {
+	isDetected bool
+	properties map[string]interface{}
+}
+
+func (r *NtpDiscoveryResult) Protocol() string {
+	return "ntp"
+}
+
+func (r *NtpDiscoveryResult) GetIsDetected() bool {
+	return r.isDetected
+}
+
+func (r *NtpDiscoveryResult) GetProperties() map[string]interface{} {
+	return r.properties
+}
+
+func (r *NtpDiscoveryResult) GetIsAuthRequired() bool {
+	return false
+}
+
+type NtpDiscovery struct {
+}
+
+func (d *NtpDiscovery) Protocol() string {
+	return "ntp"
+}
+
+func (d *NtpDiscovery) ProbeOrder() ProbeOrder {
+	return CLIENT_FIRST
+}
+
+// Discover sends an NTPv4 client request and expects a server mode answer
+func (d *NtpDiscovery) Discover(sessionHandler iSessionHandler, presentationLayerDiscoveryResult iPresentationDiscoveryResult) (iApplicationDiscoveryResult, error) {
+	err := sessionHandler.Connect()
+	if err != nil {
+		return nil, err
+	}
+	defer sessionHandler.Destory()
+
+	// LI 0, version 4, mode 3 (client)
+	request := make([]byte, ntpPacketLength)
+	request[0] = 0x23
+	if _, err := sessionHandler.Write(request); err != nil {
+		return nil, err
+	}
+
+	response := make([]byte, 1024)
+	sessionHandler.SetReadDeadline(time.Now().Add(udpProbeTimeout))
+	n, err := sessionHandler.Read(response)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		// UDP ports drop datagrams of other protocols, no answer is not an error
+		return &NtpDiscoveryResult{isDetected: false}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	response = response[:n]
+
+	// Mode 4 is a server answer
+	if len(response) < ntpPacketLength || response[0]&0x07 != 4 {
+		return &NtpDiscoveryResult{isDetected: false}, nil
+	}
+
+	stratum := response[1]
+	properties := map[string]interface{}{
+		"version": int(response[0]>>3) & 0x07,
+		"stratum": int(stratum),
+	}
+
+	// The reference ID is an ASCII source for stratum 1 and an IPv4 address above
+	refId := response[12:16]
+	if stratum <= 1 {
+		properties["referenceId"] = string(refId)
+	} else {
+		properties["referenceId"] = net.IP(refId).String()
+	}
+
+	// Transmit timestamp, seconds since 1900
+	seconds := binary.BigEndian.Uint32(response[40:44])
+	if seconds != 0 {
+		properties["serverTime"] = time.Unix(int64(seconds)-2208988800, 0).UTC().Format(time.RFC3339)
+	}
+	properties["leapIndicator"] = int(response[0] >> 6)
+
+	return &NtpDiscoveryResult{
+		isDetected: true,
+		properties: properties,
+	}, nil
+}
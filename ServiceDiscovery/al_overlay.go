@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// overlayPort describes a UDP encapsulation port used by a CNI overlay network
+type overlayPort struct {
+	port     int
+	protocol string
+	users    string
+	probe    []byte
+}
+
+// vxlanProbe is a VXLAN header (VNI 0) with an empty inner Ethernet frame, geneveProbe a Geneve header
+var (
+	vxlanProbe  = append([]byte{0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, make([]byte, 14)...)
+	geneveProbe = append([]byte{0x00, 0x00, 0x65, 0x58, 0x00, 0x00, 0x00, 0x00}, make([]byte, 14)...)
+	// A WireGuard handshake initiation (type 1) which no peer will accept
+	wireguardProbe = append([]byte{0x01, 0x00, 0x00, 0x00}, make([]byte, 144)...)
+)
+
+var overlayPorts = []overlayPort{
+	{port: 4789, protocol: "vxlan", users: "IANA VXLAN, Calico, Antrea", probe: vxlanProbe},
+	{port: 8472, protocol: "vxlan", users: "Flannel, Cilium (Linux default VXLAN port)", probe: vxlanProbe},
+	{port: 6081, protocol: "geneve", users: "Cilium, Antrea, OVN-Kubernetes", probe: geneveProbe},
+	{port: 51820, protocol: "wireguard", users: "Calico, Flannel, WireGuard", probe: wireguardProbe},
+	{port: 51871, protocol: "wireguard", users: "Cilium", probe: wireguardProbe},
+	{port: 6784, protocol: "weave-fastdp", users: "Weave Net", probe: vxlanProbe},
+}
+
+type OverlayDiscoveryResult struct {
+	isDetected bool
+	properties map[string]interface{}
+}
+
+func (r *OverlayDiscoveryResult) Protocol() string {
+	return "overlay-network"
+}
+
+func (r *OverlayDiscoveryResult) GetIsDetected() bool {
+	return r.isDetected
+}
+
+func (r *OverlayDiscoveryResult) GetProperties() map[string]interface{} {
+	return r.properties
+}
+
+func (r *OverlayDiscoveryResult) GetIsAuthRequired() bool {
+	return false
+}
+
+type OverlayDiscovery struct {
+}
+
+func (d *OverlayDiscovery) Protocol() string {
+	return "overlay-network"
+}
+
+func (d *OverlayDiscovery) ProbeOrder() ProbeOrder {
+	return CLIENT_FIRST
+}
+
+// Discover identifies overlay tunnel endpoints from a protocol-valid reply to a
+// protocol shaped datagram. Encapsulation endpoints usually stay silent, which
+// can not be told apart from a filtered port, so silence is reported as
+// "open|filtered" with the encapsulation of the well-known port as a hint only.
+func (d *OverlayDiscovery) Discover(sessionHandler iSessionHandler, presentationLayerDiscoveryResult iPresentationDiscoveryResult) (iApplicationDiscoveryResult, error) {
+	var overlay *overlayPort
+	for i := range overlayPorts {
+		if overlayPorts[i].port == sessionHandler.GetPort() {
+			overlay = &overlayPorts[i]
+			break
+		}
+	}
+	if overlay == nil {
+		return &OverlayDiscoveryResult{isDetected: false}, nil
+	}
+
+	err := sessionHandler.Connect()
+	if err != nil {
+		return nil, err
+	}
+	defer sessionHandler.Destory()
+
+	if _, err := sessionHandler.Write(overlay.probe); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1024)
+	sessionHandler.SetReadDeadline(time.Now().Add(udpProbeTimeout))
+	n, err := sessionHandler.Read(buf)
+	var netErr net.Error
+	if err != nil {
+		state := "closed"
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			state = "open|filtered"
+		}
+		return &OverlayDiscoveryResult{
+			isDetected: false,
+			properties: map[string]interface{}{
+				"state":         state,
+				"portHint":      overlay.protocol,
+				"portHintUsers": overlay.users,
+			},
+		}, nil
+	}
+	if !overlayReplyValid(overlay.protocol, buf[:n]) {
+		return &OverlayDiscoveryResult{isDetected: false}, nil
+	}
+
+	return &OverlayDiscoveryResult{
+		isDetected: true,
+		properties: map[string]interface{}{
+			"encapsulation": overlay.protocol,
+			"usedBy":        overlay.users,
+			"confidence":    "reply",
+		},
+	}, nil
+}
+
+// overlayReplyValid checks that a reply is a message of the encapsulation
+// protocol: a WireGuard handshake response (type 2) or cookie reply (type 3),
+// or a VXLAN or Geneve encapsulated frame sent back by the endpoint
+func overlayReplyValid(protocol string, reply []byte) bool {
+	switch protocol {
+	case "wireguard":
+		if len(reply) < 4 || reply[1] != 0 || reply[2] != 0 || reply[3] != 0 {
+			return false
+		}
+		return (reply[0] == 2 && len(reply) == 92) || (reply[0] == 3 && len(reply) == 64)
+	case "vxlan", "weave-fastdp":
+		// The I flag is set and the reserved bits are zero
+		return len(reply) >= 8+14 && reply[0] == 0x08 && reply[1] == 0 && reply[2] == 0 && reply[3] == 0 && reply[7] == 0
+	case "geneve":
+		// Version 0, options length in 4 byte words, Ethernet payload
+		if len(reply) < 8+14 {
+			return false
+		}
+		optionsLength := int(reply[0]&0x3f) * 4
+		return reply[0]>>6 == 0 && reply[2] == 0x65 && reply[3] == 0x58 && len(reply) >= 8+optionsLength+14
+	}
+	return false
+}
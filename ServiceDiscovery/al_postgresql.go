@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"net"
 	"strings"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type PostgresDiscoveryResult struct {
@@ -37,17 +41,23 @@ func (d *PostgresDiscovery) Protocol() string {
 	return "postgresql"
 }
 
+func (d *PostgresDiscovery) ProbeOrder() ProbeOrder {
+	return CLIENT_FIRST
+}
+
 func (d *PostgresDiscovery) Discover(sessionHandler iSessionHandler, presentationLayerDiscoveryResult iPresentationDiscoveryResult) (iApplicationDiscoveryResult, error) {
-	// Use the provided sessionHandler to create a new connection to the PostgreSQL server
-	db, err := sql.Open("postgres", fmt.Sprintf("host=%s port=%d sslmode=disable user=postgres password=admin123", sessionHandler.GetHost(), sessionHandler.GetPort()))
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to PostgreSQL server: %v", err)
-	}
+	// lib/pq runs over the session handler, which already negotiated TLS
+	// in-protocol when the session layer upgraded the connection
+	tlsMode := sessionTlsMode(sessionHandler)
+	db := sql.OpenDB(&postgresConnector{
+		dsn:            fmt.Sprintf("host=%s port=%d sslmode=disable connect_timeout=5 user=postgres password=admin123", sessionHandler.GetHost(), sessionHandler.GetPort()),
+		sessionHandler: sessionHandler,
+	})
 	defer db.Close()
 
 	// Use the connection to query the PostgreSQL server for its version
 	var version string
-	err = db.QueryRow("SELECT version()").Scan(&version)
+	err := db.QueryRow("SELECT version()").Scan(&version)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query PostgreSQL server: %v", err)
 	}
@@ -58,9 +68,78 @@ func (d *PostgresDiscovery) Discover(sessionHandler iSessionHandler, presentatio
 			isDetected: true,
 			properties: map[string]interface{}{
 				"version": version,
+				"tls":     tlsMode,
 			},
 		}, nil
 	} else {
 		return nil, nil
 	}
 }
+
+// postgresConnector opens lib/pq connections over the session handler, so the
+// STARTTLS upgrade and the -proxy dialer of the session layer are used
+type postgresConnector struct {
+	dsn            string
+	sessionHandler iSessionHandler
+}
+
+func (c *postgresConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return pq.DialOpen(postgresDialer{sessionHandler: c.sessionHandler}, c.dsn)
+}
+
+func (c *postgresConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
+// postgresDialer adapts the session handler to the pq.Dialer interface
+type postgresDialer struct {
+	sessionHandler iSessionHandler
+}
+
+func (d postgresDialer) Dial(network, address string) (net.Conn, error) {
+	if err := d.sessionHandler.Connect(); err != nil {
+		return nil, err
+	}
+	return &sessionConn{sessionHandler: d.sessionHandler}, nil
+}
+
+func (d postgresDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	return d.Dial(network, address)
+}
+
+// sessionConn is the net.Conn of lib/pq, a session handler only has read deadlines
+type sessionConn struct {
+	sessionHandler iSessionHandler
+}
+
+func (c *sessionConn) Read(data []byte) (int, error) {
+	return c.sessionHandler.Read(data)
+}
+
+func (c *sessionConn) Write(data []byte) (int, error) {
+	return c.sessionHandler.Write(data)
+}
+
+func (c *sessionConn) Close() error {
+	return c.sessionHandler.Destory()
+}
+
+func (c *sessionConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func (c *sessionConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(c.sessionHandler.GetHost()), Port: c.sessionHandler.GetPort()}
+}
+
+func (c *sessionConn) SetDeadline(t time.Time) error {
+	return c.sessionHandler.SetReadDeadline(t)
+}
+
+func (c *sessionConn) SetReadDeadline(t time.Time) error {
+	return c.sessionHandler.SetReadDeadline(t)
+}
+
+func (c *sessionConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
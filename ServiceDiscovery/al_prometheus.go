@@ -0,0 +1,372 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+type PrometheusMetricsDiscoveryResult struct {
+	isDetected bool
+	properties map[string]interface{}
+	auth       *HttpAuthClassification
+}
+
+func (r *PrometheusMetricsDiscoveryResult) Protocol() string {
+	return "prometheus-metrics"
+}
+
+func (r *PrometheusMetricsDiscoveryResult) GetIsDetected() bool {
+	return r.isDetected
+}
+
+func (r *PrometheusMetricsDiscoveryResult) GetProperties() map[string]interface{} {
+	return r.properties
+}
+
+// GetIsAuthRequired is false whenever the metrics were served to an anonymous request
+func (r *PrometheusMetricsDiscoveryResult) GetIsAuthRequired() bool {
+	return r.auth.IsAuthRequired()
+}
+
+type PrometheusMetricsDiscovery struct {
+}
+
+func (d *PrometheusMetricsDiscovery) Protocol() string {
+	return "prometheus-metrics"
+}
+
+func (d *PrometheusMetricsDiscovery) ProbeOrder() ProbeOrder {
+	return CLIENT_FIRST
+}
+
+// metricSample is a single sample line of the exposition format
+type metricSample struct {
+	name   string
+	labels map[string]string
+	value  string
+}
+
+// metricComponentPrefixes maps metric name prefixes to the component exposing them.
+// Kubernetes components share many metrics (apiserver_*, rest_client_*), so the
+// prefixes only one of them exposes come first and the first match wins.
+var metricComponentPrefixes = []struct {
+	prefix    string
+	component string
+}{
+	{"kubelet_", "kubelet"},
+	{"scheduler_", "kube-scheduler"},
+	{"node_collector_", "kube-controller-manager"},
+	{"endpoint_slice_controller_", "kube-controller-manager"},
+	{"kubeproxy_", "kube-proxy"},
+	{"etcd_server_", "etcd"},
+	{"apiserver_request_", "kube-apiserver"},
+	{"coredns_", "coredns"},
+	{"kube_pod_", "kube-state-metrics"},
+	{"node_exporter_", "node-exporter"},
+	{"prometheus_", "prometheus"},
+	{"alertmanager_", "alertmanager"},
+	{"grafana_", "grafana"},
+	{"cilium_", "cilium"},
+	{"felix_", "calico"},
+	{"nginx_ingress_controller_", "ingress-nginx"},
+	{"traefik_", "traefik"},
+	{"envoy_", "envoy"},
+	{"containerd_", "containerd"},
+	{"argocd_", "argo-cd"},
+}
+
+// metricSensitiveLabels are label names whose values reveal workloads,
+// infrastructure or credentials
+var metricSensitiveLabels = []string{
+	"namespace", "pod", "container", "image", "node", "instance", "host", "hostname",
+	"ip", "pod_ip", "host_ip", "endpoint", "service", "path", "url", "uri", "user",
+	"username", "email", "database", "db", "secret", "token", "password", "key",
+}
+
+// metricCredentialLabels are the sensitive labels which may carry credentials,
+// the others reveal workloads and infrastructure
+var metricCredentialLabels = []string{"secret", "token", "password", "key"}
+
+// metricFindingSeries bounds the series names listed in a finding
+const metricFindingSeries = 5
+
+// Discover parses /metrics from the HTTP path cache
+func (d *PrometheusMetricsDiscovery) Discover(sessionHandler iSessionHandler, presentationLayerDiscoveryResult iPresentationDiscoveryResult) (iApplicationDiscoveryResult, error) {
+	resp, err := httpProbePath(sessionHandler, "/metrics")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return &PrometheusMetricsDiscoveryResult{isDetected: false}, nil
+	}
+
+	samples, families := parseMetrics(string(resp.Body))
+	if len(samples) == 0 || (len(families) == 0 && !strings.Contains(resp.Header.Get("Content-Type"), "version=0.0.4")) {
+		return &PrometheusMetricsDiscoveryResult{isDetected: false}, nil
+	}
+
+	format := "prometheus"
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/openmetrics-text") || strings.Contains(string(resp.Body), "# EOF") {
+		format = "openmetrics"
+	}
+
+	auth := httpClassifyAuth(sessionHandler, "/metrics")
+	unauthenticated := !auth.IsAuthRequired()
+	properties := map[string]interface{}{
+		"format":          format,
+		"metricFamilies":  len(families),
+		"samples":         len(samples),
+		"unauthenticated": unauthenticated,
+		"auth":            auth.Properties(),
+		"evidence":        httpPathEvidence(sessionHandler, "/metrics"),
+	}
+
+	component, version, buildInfo := metricsComponent(samples)
+	if component != "" {
+		properties["component"] = component
+	}
+	if version != "" {
+		properties["version"] = version
+	}
+	if buildInfo != nil {
+		properties["buildInfo"] = buildInfo
+	}
+	leaked := metricsSensitiveLabels(samples)
+	if len(leaked) > 0 {
+		properties["sensitiveLabels"] = leaked
+	}
+	if unauthenticated {
+		properties["findings"] = []Finding{metricsExposureFinding(component, samples, leaked)}
+	}
+
+	return &PrometheusMetricsDiscoveryResult{
+		isDetected: true,
+		auth:       auth,
+		properties: properties,
+	}, nil
+}
+
+// parseMetrics parses the Prometheus text and OpenMetrics exposition formats and
+// returns the samples and the metric families declared with # TYPE
+func parseMetrics(body string) ([]metricSample, map[string]string) {
+	var samples []metricSample
+	families := make(map[string]string)
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				families[fields[2]] = fields[3]
+			}
+			continue
+		}
+		sample, ok := parseMetricSample(line)
+		if !ok {
+			// Not an exposition format at all
+			if len(samples) == 0 {
+				return nil, nil
+			}
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	return samples, families
+}
+
+// parseMetricSample parses `name{label="value",...} value [timestamp]`
+func parseMetricSample(line string) (metricSample, bool) {
+	sample := metricSample{labels: make(map[string]string)}
+
+	end := strings.IndexAny(line, "{ ")
+	if end <= 0 {
+		return sample, false
+	}
+	sample.name = line[:end]
+	if !isMetricName(sample.name) {
+		return sample, false
+	}
+	rest := line[end:]
+
+	if strings.HasPrefix(rest, "{") {
+		rest = rest[1:]
+		for {
+			rest = strings.TrimLeft(rest, " ,")
+			if strings.HasPrefix(rest, "}") {
+				rest = rest[1:]
+				break
+			}
+			eq := strings.Index(rest, "=\"")
+			if eq <= 0 {
+				return sample, false
+			}
+			name := strings.TrimSpace(rest[:eq])
+			rest = rest[eq+2:]
+
+			// Label values escape backslash, quote and newline
+			var value strings.Builder
+			closed := false
+			for i := 0; i < len(rest); i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+					if rest[i] == 'n' {
+						value.WriteByte('\n')
+					} else {
+						value.WriteByte(rest[i])
+					}
+					continue
+				}
+				if rest[i] == '"' {
+					rest = rest[i+1:]
+					closed = true
+					break
+				}
+				value.WriteByte(rest[i])
+			}
+			if !closed {
+				return sample, false
+			}
+			sample.labels[name] = value.String()
+		}
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return sample, false
+	}
+	sample.value = fields[0]
+	return sample, true
+}
+
+func isMetricName(name string) bool {
+	for i, c := range name {
+		if c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// metricsComponent identifies the component from *_build_info metrics and from
+// well-known metric name prefixes
+func metricsComponent(samples []metricSample) (string, string, map[string]string) {
+	component := ""
+	version := ""
+	var buildInfo map[string]string
+
+	for _, sample := range samples {
+		// go_build_info only describes the Go module of the binary
+		if sample.name == "go_build_info" || (!strings.HasSuffix(sample.name, "_build_info") && sample.name != "etcd_server_version") {
+			continue
+		}
+		// The build info of a named component wins over kubernetes_build_info,
+		// e.g. for a Prometheus server re-exposing scraped Kubernetes series
+		if sample.name == "kubernetes_build_info" && component != "" {
+			continue
+		}
+		buildInfo = sample.labels
+		for _, label := range []string{"git_version", "gitVersion", "version", "server_version"} {
+			if value := sample.labels[label]; value != "" {
+				version = value
+				break
+			}
+		}
+		if sample.name != "kubernetes_build_info" {
+			component = strings.TrimSuffix(strings.TrimSuffix(sample.name, "_build_info"), "_server_version")
+		}
+	}
+	if component != "" {
+		return component, version, buildInfo
+	}
+
+	// kubernetes_build_info is exposed by every Kubernetes component, the
+	// metric names tell which one it is
+	for _, entry := range metricComponentPrefixes {
+		for _, sample := range samples {
+			if strings.HasPrefix(sample.name, entry.prefix) {
+				return entry.component, version, buildInfo
+			}
+		}
+	}
+	return component, version, buildInfo
+}
+
+// metricsExposureFinding rates metrics served without authentication by the
+// most sensitive label class they leak and lists the series carrying them.
+// Label values are never part of the finding.
+func metricsExposureFinding(component string, samples []metricSample, leaked map[string]int) Finding {
+	name := "/metrics"
+	if component != "" {
+		name = component + " /metrics"
+	}
+	if len(leaked) == 0 {
+		return Finding{
+			Severity: SEVERITY_LOW,
+			Title:    name + " is served without authentication",
+			Detail:   "no sensitive labels found",
+		}
+	}
+
+	severity := SEVERITY_MEDIUM
+	var labels []string
+	for label := range leaked {
+		labels = append(labels, label)
+		for _, credential := range metricCredentialLabels {
+			if label == credential {
+				severity = SEVERITY_HIGH
+			}
+		}
+	}
+	sort.Strings(labels)
+
+	var series []string
+	seen := make(map[string]bool)
+	for _, sample := range samples {
+		if seen[sample.name] || len(series) >= metricFindingSeries {
+			continue
+		}
+		var carried []string
+		for _, label := range labels {
+			if _, ok := sample.labels[label]; ok {
+				carried = append(carried, label)
+			}
+		}
+		if len(carried) > 0 {
+			seen[sample.name] = true
+			series = append(series, sample.name+"{"+strings.Join(carried, ",")+"}")
+		}
+	}
+	return Finding{
+		Severity: severity,
+		Title:    name + " is served without authentication and leaks sensitive labels",
+		Detail:   "labels " + strings.Join(labels, ", ") + " in series such as " + strings.Join(series, ", "),
+	}
+}
+
+// metricsSensitiveLabels returns the number of distinct values of every
+// sensitive label found in the samples
+func metricsSensitiveLabels(samples []metricSample) map[string]int {
+	values := make(map[string]map[string]bool)
+	for _, sample := range samples {
+		for name, value := range sample.labels {
+			for _, sensitive := range metricSensitiveLabels {
+				if name != sensitive {
+					continue
+				}
+				if values[name] == nil {
+					values[name] = make(map[string]bool)
+				}
+				values[name][value] = true
+			}
+		}
+	}
+
+	leaked := make(map[string]int)
+	for name, distinct := range values {
+		leaked[name] = len(distinct)
+	}
+	return leaked
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMetrics(t *testing.T) {
+	body := `# HELP kubelet_running_pods Number of pods running
+# TYPE kubelet_running_pods gauge
+kubelet_running_pods 12
+# TYPE apiserver_request_total counter
+apiserver_request_total{code="200",path="/api/v1",verb="GET"} 4 1700000000000
+label_escapes{value="a\"b\\c\nd",other="x",} 1.5e+3
+no_labels_timestamp NaN 1700000000000
+`
+	samples, families := parseMetrics(body)
+
+	wantFamilies := map[string]string{"kubelet_running_pods": "gauge", "apiserver_request_total": "counter"}
+	if !reflect.DeepEqual(families, wantFamilies) {
+		t.Errorf("families = %v, want %v", families, wantFamilies)
+	}
+	want := []metricSample{
+		{name: "kubelet_running_pods", labels: map[string]string{}, value: "12"},
+		{name: "apiserver_request_total", labels: map[string]string{"code": "200", "path": "/api/v1", "verb": "GET"}, value: "4"},
+		{name: "label_escapes", labels: map[string]string{"value": "a\"b\\c\nd", "other": "x"}, value: "1.5e+3"},
+		{name: "no_labels_timestamp", labels: map[string]string{}, value: "NaN"},
+	}
+	if !reflect.DeepEqual(samples, want) {
+		t.Errorf("samples = %+v, want %+v", samples, want)
+	}
+}
+
+func TestParseMetricsRejects(t *testing.T) {
+	for _, body := range []string{
+		"<html><body>metrics</body></html>",
+		`{"metrics": []}`,
+		"unterminated{label=\"value} 1",
+		"9starts_with_digit 1",
+		"missing_value{a=\"b\"}",
+	} {
+		if samples, _ := parseMetrics(body); len(samples) != 0 {
+			t.Errorf("parseMetrics(%q) = %+v, want no samples", body, samples)
+		}
+	}
+}
+
+func TestMetricsComponent(t *testing.T) {
+	sample := func(name string, labels map[string]string) metricSample {
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		return metricSample{name: name, labels: labels, value: "1"}
+	}
+	tests := []struct {
+		name          string
+		samples       []metricSample
+		wantComponent string
+		wantVersion   string
+	}{
+		{
+			name: "kubernetes_build_info resolved by prefix",
+			samples: []metricSample{
+				sample("kubernetes_build_info", map[string]string{"git_version": "v1.29.2"}),
+				sample("apiserver_request_total", nil),
+				sample("kubelet_running_pods", nil),
+			},
+			wantComponent: "kubelet",
+			wantVersion:   "v1.29.2",
+		},
+		{
+			name: "prometheus re-exposing kubelet series",
+			samples: []metricSample{
+				sample("prometheus_build_info", map[string]string{"version": "2.48.0"}),
+				sample("kubelet_running_pods", nil),
+				sample("kubernetes_build_info", map[string]string{"git_version": "v1.29.2"}),
+			},
+			wantComponent: "prometheus",
+			wantVersion:   "2.48.0",
+		},
+		{
+			name: "etcd server version",
+			samples: []metricSample{
+				sample("etcd_server_version", map[string]string{"server_version": "3.5.12"}),
+				sample("go_build_info", map[string]string{"version": "go1.21"}),
+			},
+			wantComponent: "etcd",
+			wantVersion:   "3.5.12",
+		},
+		{
+			name:          "prefix only",
+			samples:       []metricSample{sample("coredns_dns_requests_total", nil)},
+			wantComponent: "coredns",
+		},
+		{
+			name:    "unknown",
+			samples: []metricSample{sample("http_requests_total", nil)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			component, version, _ := metricsComponent(test.samples)
+			if component != test.wantComponent || version != test.wantVersion {
+				t.Errorf("metricsComponent() = %q, %q, want %q, %q", component, version, test.wantComponent, test.wantVersion)
+			}
+		})
+	}
+}
+
+func TestMetricsExposureFinding(t *testing.T) {
+	sample := func(name string, labels map[string]string) metricSample {
+		return metricSample{name: name, labels: labels, value: "1"}
+	}
+	tests := []struct {
+		name         string
+		samples      []metricSample
+		wantSeverity FindingSeverity
+		wantDetail   string
+	}{
+		{
+			name:         "no sensitive labels",
+			samples:      []metricSample{sample("process_cpu_seconds_total", map[string]string{})},
+			wantSeverity: SEVERITY_LOW,
+			wantDetail:   "no sensitive labels found",
+		},
+		{
+			name: "workload labels",
+			samples: []metricSample{
+				sample("kube_pod_info", map[string]string{"namespace": "prod", "pod": "api-0"}),
+				sample("kube_pod_info", map[string]string{"namespace": "prod", "pod": "api-1"}),
+				sample("up", map[string]string{"instance": "10.0.0.1:9100"}),
+			},
+			wantSeverity: SEVERITY_MEDIUM,
+			wantDetail:   "labels instance, namespace, pod in series such as kube_pod_info{namespace,pod}, up{instance}",
+		},
+		{
+			name: "credential labels",
+			samples: []metricSample{
+				sample("webhook_requests_total", map[string]string{"url": "https://hooks", "token": "abc"}),
+			},
+			wantSeverity: SEVERITY_HIGH,
+			wantDetail:   "labels token, url in series such as webhook_requests_total{token,url}",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			finding := metricsExposureFinding("", test.samples, metricsSensitiveLabels(test.samples))
+			if finding.Severity != test.wantSeverity || finding.Detail != test.wantDetail {
+				t.Errorf("finding = [%s] %s, want [%s] %s", finding.Severity, finding.Detail, test.wantSeverity, test.wantDetail)
+			}
+			for _, sample := range test.samples {
+				for _, value := range sample.labels {
+					if strings.Contains(finding.Detail, value) {
+						t.Errorf("finding leaks label value %q", value)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPrometheusMetricsDiscoveryFinding(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			w.WriteHeader(404)
+			return
+		}
+		w.Write([]byte("# TYPE kube_pod_info gauge\nkube_pod_info{namespace=\"prod\",pod=\"api-0\"} 1\n"))
+	}))
+	defer server.Close()
+	sessionHandler := sharedHandlerFor(t, server.Listener.Addr().String())
+
+	result, err := (&PrometheusMetricsDiscovery{}).Discover(sessionHandler, nil)
+	if err != nil || !result.GetIsDetected() {
+		t.Fatalf("detected=%v err=%v", result != nil && result.GetIsDetected(), err)
+	}
+	findings, _ := result.GetProperties()["findings"].([]Finding)
+	if len(findings) != 1 || findings[0].Severity != SEVERITY_MEDIUM {
+		t.Fatalf("findings = %+v, want one medium finding", findings)
+	}
+	if want := "kube-state-metrics /metrics is served without authentication and leaks sensitive labels"; findings[0].Title != want {
+		t.Errorf("title = %q, want %q", findings[0].Title, want)
+	}
+}
@@ -2,15 +2,18 @@ package main
 
 import (
 	"errors"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type RedisDiscovery struct {
 }
 
 type RedisDiscoveryResult struct {
-	isDetected bool
-	properties map[string]interface{}
+	isDetected   bool
+	authRequired bool
+	properties   map[string]interface{}
 }
 
 func (d *RedisDiscoveryResult) Protocol() string {
@@ -21,8 +24,12 @@ func (d *RedisDiscovery) Protocol() string {
 	return "redis"
 }
 
+func (d *RedisDiscovery) ProbeOrder() ProbeOrder {
+	return CLIENT_FIRST
+}
+
 func (r *RedisDiscoveryResult) GetIsAuthRequired() bool {
-	return false
+	return r.authRequired
 }
 
 func (r *RedisDiscoveryResult) GetIsDetected() bool {
@@ -39,6 +46,7 @@ func (d *RedisDiscovery) Discover(sessionHandler iSessionHandler, presentationLa
 	if err != nil {
 		return nil, err
 	}
+	defer sessionHandler.Destory()
 
 	// Send the INFO command to Redis
 	_, err = sessionHandler.Write([]byte("*1\r\n$4\r\nINFO\r\n"))
@@ -46,32 +54,38 @@ func (d *RedisDiscovery) Discover(sessionHandler iSessionHandler, presentationLa
 		return nil, err
 	}
 
-	// Read the response from Redis
-	headerBuf := make([]byte, 1024)
-	_, err = sessionHandler.Read(headerBuf)
-	if err != nil {
-		return nil, err
+	// Read the response from Redis, INFO answers with a bulk string "$<length>\r\n<info>\r\n"
+	sessionHandler.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer sessionHandler.SetReadDeadline(time.Time{})
+	response := make([]byte, 0, 4096)
+	buf := make([]byte, 4096)
+	for {
+		n, err := sessionHandler.Read(buf)
+		response = append(response, buf[:n]...)
+		if err != nil || redisReplyComplete(response) {
+			break
+		}
 	}
-	line := string(headerBuf)
+	line, body, _ := strings.Cut(string(response), "\r\n")
 
-	// Check if the response is valid
-	if !strings.HasPrefix(line, "+") {
-		return nil, errors.New("invalid response from Redis")
+	// Errors before the reply mean the server is Redis but requires a password
+	// (NOAUTH) or only accepts loopback clients (DENIED, protected mode)
+	if strings.HasPrefix(line, "-NOAUTH") || strings.HasPrefix(line, "-DENIED") {
+		return &RedisDiscoveryResult{
+			isDetected:   true,
+			authRequired: true,
+			properties: map[string]interface{}{
+				"error": strings.TrimPrefix(line, "-"),
+			},
+		}, nil
+	}
+	if !strings.HasPrefix(line, "$") {
+		return &RedisDiscoveryResult{isDetected: false}, nil
 	}
 
 	// Parse the response and extract the Redis version
 	info := make(map[string]string)
-	for {
-		lineBuf := make([]byte, 1024)
-		n, err := sessionHandler.Read(lineBuf)
-		if err != nil {
-			return nil, err
-		}
-		line = string(lineBuf[:n])
-		line = strings.TrimSpace(line)
-		if line == "" {
-			break
-		}
+	for _, line := range strings.Split(body, "\r\n") {
 		parts := strings.SplitN(line, ":", 2)
 		if len(parts) != 2 {
 			continue
@@ -88,7 +102,21 @@ func (d *RedisDiscovery) Discover(sessionHandler iSessionHandler, presentationLa
 		isDetected: true,
 		properties: map[string]interface{}{
 			"version": version,
+			"mode":    info["redis_mode"],
 		},
 	}, nil
 }
 
+// redisReplyComplete tells whether a simple reply, an error or the whole bulk
+// string announced by its length has been read
+func redisReplyComplete(response []byte) bool {
+	line, rest, found := strings.Cut(string(response), "\r\n")
+	if !found {
+		return false
+	}
+	if !strings.HasPrefix(line, "$") {
+		return true
+	}
+	length, err := strconv.Atoi(line[1:])
+	return err != nil || len(rest) >= length+2
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type WebUiDiscoveryResult struct {
+	isDetected bool
+	product    string
+	properties map[string]interface{}
+	auth       *HttpAuthClassification
+}
+
+// Protocol returns the product name of the matched fingerprint
+func (r *WebUiDiscoveryResult) Protocol() string {
+	if r.product != "" {
+		return r.product
+	}
+	return "web-ui"
+}
+
+func (r *WebUiDiscoveryResult) GetIsDetected() bool {
+	return r.isDetected
+}
+
+func (r *WebUiDiscoveryResult) GetProperties() map[string]interface{} {
+	return r.properties
+}
+
+// GetIsAuthRequired tells whether the UI asks for a login before showing anything
+func (r *WebUiDiscoveryResult) GetIsAuthRequired() bool {
+	return r.auth.IsAuthRequired()
+}
+
+type WebUiDiscovery struct {
+}
+
+func (d *WebUiDiscovery) Protocol() string {
+	return "web-ui"
+}
+
+func (d *WebUiDiscovery) ProbeOrder() ProbeOrder {
+	return CLIENT_FIRST
+}
+
+// WebUiFingerprint maps what a web UI serves to its product name. Any single
+// matching signal identifies the product, more matches make it more certain.
+type WebUiFingerprint struct {
+	Product string `json:"product"`
+	// Titles are case-insensitive substrings of the page title
+	Titles []string `json:"titles,omitempty"`
+	// Generators are case-insensitive substrings of the generator meta tag
+	Generators []string `json:"generators,omitempty"`
+	// FaviconMmh3 are Shodan style favicon hashes
+	FaviconMmh3   []int32  `json:"faviconMmh3,omitempty"`
+	FaviconSha256 []string `json:"faviconSha256,omitempty"`
+	// Headers maps a header name to a substring of its value, an empty
+	// substring only requires the header to be present
+	Headers map[string]string `json:"headers,omitempty"`
+	// BodyMarkers are substrings of the root page
+	BodyMarkers []string `json:"bodyMarkers,omitempty"`
+	// VersionHeader names a header carrying the product version
+	VersionHeader string `json:"versionHeader,omitempty"`
+}
+
+// WebUiFingerprints is the bundled fingerprint table, more entries can be
+// loaded from a JSON file with LoadWebUiFingerprints
+var WebUiFingerprints = []WebUiFingerprint{
+	{
+		Product:     "grafana",
+		Titles:      []string{"Grafana"},
+		FaviconMmh3: []int32{2123863676},
+		BodyMarkers: []string{"grafanaBootData", "grafana-app"},
+	},
+	{
+		Product:     "kibana",
+		Titles:      []string{"Kibana", "Elastic"},
+		Headers:     map[string]string{"kbn-name": ""},
+		BodyMarkers: []string{"kbn-injected-metadata", "__kbnBootstrap__"},
+	},
+	{
+		Product:     "kubernetes-dashboard",
+		Titles:      []string{"Kubernetes Dashboard"},
+		BodyMarkers: []string{"<kd-root", "kubernetes-dashboard"},
+	},
+	{
+		Product:     "argo-cd",
+		Titles:      []string{"Argo CD"},
+		BodyMarkers: []string{"argo-cd"},
+	},
+	{
+		Product:       "jenkins",
+		Titles:        []string{"[Jenkins]", "Jenkins"},
+		FaviconMmh3:   []int32{81586312},
+		Headers:       map[string]string{"X-Jenkins": ""},
+		VersionHeader: "X-Jenkins",
+	},
+	{
+		Product: "rancher",
+		Titles:  []string{"Rancher"},
+		Headers: map[string]string{"X-Api-Schemas": ""},
+	},
+}
+
+// LoadWebUiFingerprints appends the fingerprints of a JSON file holding an
+// array of WebUiFingerprint objects to the bundled table
+func LoadWebUiFingerprints(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var fingerprints []WebUiFingerprint
+	if err := json.Unmarshal(data, &fingerprints); err != nil {
+		return err
+	}
+	WebUiFingerprints = append(WebUiFingerprints, fingerprints...)
+	return nil
+}
+
+// Discover matches the root page and favicon of the endpoint against the fingerprint table
+func (d *WebUiDiscovery) Discover(sessionHandler iSessionHandler, presentationLayerDiscoveryResult iPresentationDiscoveryResult) (iApplicationDiscoveryResult, error) {
+	// Redirects within the endpoint are followed, e.g. to a login page
+	auth := httpClassifyAuth(sessionHandler, "/")
+	resp, err := httpProbePath(sessionHandler, auth.FinalPath)
+	if err != nil {
+		return nil, err
+	}
+	favicon := httpFavicon(sessionHandler, resp)
+
+	var best *WebUiFingerprint
+	var bestMatches []string
+	for i := range WebUiFingerprints {
+		matches := WebUiFingerprints[i].match(resp, favicon)
+		if len(matches) > len(bestMatches) {
+			best = &WebUiFingerprints[i]
+			bestMatches = matches
+		}
+	}
+	if best == nil {
+		return &WebUiDiscoveryResult{isDetected: false}, nil
+	}
+
+	properties := map[string]interface{}{
+		"product": best.Product,
+		"matched": bestMatches,
+		"title":   resp.Title,
+		"auth":    auth.Properties(),
+	}
+	if best.VersionHeader != "" && resp.Header.Get(best.VersionHeader) != "" {
+		properties["version"] = resp.Header.Get(best.VersionHeader)
+	}
+	if resp.Generator != "" {
+		properties["generator"] = resp.Generator
+	}
+	if favicon != nil {
+		properties["faviconMmh3"] = favicon.Mmh3
+		properties["faviconSha256"] = favicon.Sha256
+	}
+
+	return &WebUiDiscoveryResult{
+		isDetected: true,
+		product:    best.Product,
+		properties: properties,
+		auth:       auth,
+	}, nil
+}
+
+// match returns a description of every signal of the fingerprint found in the response
+func (f *WebUiFingerprint) match(resp *HttpResponse, favicon *HttpFavicon) []string {
+	var matches []string
+	title := strings.ToLower(resp.Title)
+	for _, candidate := range f.Titles {
+		if title != "" && strings.Contains(title, strings.ToLower(candidate)) {
+			matches = append(matches, "title:"+candidate)
+			break
+		}
+	}
+	generator := strings.ToLower(resp.Generator)
+	for _, candidate := range f.Generators {
+		if generator != "" && strings.Contains(generator, strings.ToLower(candidate)) {
+			matches = append(matches, "generator:"+candidate)
+			break
+		}
+	}
+	if favicon != nil {
+		for _, hash := range f.FaviconMmh3 {
+			if favicon.Mmh3 == hash {
+				matches = append(matches, "faviconMmh3")
+			}
+		}
+		for _, hash := range f.FaviconSha256 {
+			if strings.EqualFold(favicon.Sha256, hash) {
+				matches = append(matches, "faviconSha256")
+			}
+		}
+	}
+	for name, value := range f.Headers {
+		if values, ok := resp.Header[http.CanonicalHeaderKey(name)]; ok && strings.Contains(strings.Join(values, ", "), value) {
+			matches = append(matches, "header:"+name)
+		}
+	}
+	body := string(resp.Body)
+	for _, marker := range f.BodyMarkers {
+		if strings.Contains(body, marker) {
+			matches = append(matches, "body:"+marker)
+			break
+		}
+	}
+	return matches
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// mysqlGreeting builds an initial handshake packet of protocol version 10
+// without the SSL capability, so the session layer stays plain TCP
+func mysqlGreeting(version string) []byte {
+	payload := []byte{mysqlProtocolVersion}
+	payload = append(payload, version...)
+	payload = append(payload, 0)
+	payload = binary.LittleEndian.AppendUint32(payload, 42)
+	payload = append(payload, "abcdefgh"...)
+	payload = append(payload, 0)
+	payload = binary.LittleEndian.AppendUint16(payload, 0xf7ff&^mysqlClientSSL)
+	payload = append(payload, 0x21, 0x02, 0x00)
+	payload = binary.LittleEndian.AppendUint16(payload, 0x0000)
+	payload = append(payload, 21)
+	payload = append(payload, make([]byte, 10)...)
+	payload = append(payload, "ijklmnopqrst\x00"...)
+	payload = append(payload, "mysql_native_password\x00"...)
+	header := []byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), 0}
+	return append(header, payload...)
+}
+
+// bannerServer greets every connection with banner and records what clients
+// write once recording is enabled
+type bannerServer struct {
+	listener  net.Listener
+	banner    []byte
+	mutex     sync.Mutex
+	recording bool
+	received  []byte
+}
+
+func newBannerServer(t *testing.T, banner []byte) *bannerServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &bannerServer{listener: listener, banner: banner}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return server
+}
+
+func (s *bannerServer) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.Write(s.banner)
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		s.mutex.Lock()
+		if s.recording {
+			s.received = append(s.received, buf[:n]...)
+		}
+		s.mutex.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *bannerServer) record() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.recording = true
+}
+
+func (s *bannerServer) written() []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]byte(nil), s.received...)
+}
+
+// sharedHandlerFor classifies the session layer of a local TCP port
+func sharedHandlerFor(t *testing.T, address string) *SharedSessionHandler {
+	host, portStr, _ := net.SplitHostPort(address)
+	port, _ := strconv.Atoi(portStr)
+	sessionDiscoveryResult, _, err := ClassifySessionLayer(host, port, TCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionHandler, err := NewSharedSessionHandler(sessionDiscoveryResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sessionHandler.Close() })
+	return sessionHandler
+}
+
+func TestBannerProtocolDetectedBeforeClientFirstProbes(t *testing.T) {
+	server := newBannerServer(t, mysqlGreeting("8.0.34"))
+	sessionHandler := sharedHandlerFor(t, server.listener.Addr().String())
+
+	// Session layer discovery has its own connections, only the probe phases count
+	server.record()
+	if !discoverProbePhases(sessionHandler, TCP) {
+		t.Fatal("MySQL was not detected from its banner")
+	}
+	if err := sessionHandler.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if written := server.written(); len(written) > 0 {
+		t.Fatalf("client-first probes wrote %q to a banner protocol", written)
+	}
+
+	mysql, err := (&MysqlDiscovery{}).Discover(sessionHandler, nil)
+	if err != nil || !mysql.GetIsDetected() {
+		t.Fatalf("MysqlDiscovery: detected=%v err=%v", mysql != nil && mysql.GetIsDetected(), err)
+	}
+	if version := string(mysql.GetProperties()["ServerVersion"].([]byte)); version != "8.0.34" {
+		t.Errorf("ServerVersion = %q, want 8.0.34", version)
+	}
+}
+
+func TestClientFirstProbesRunWithoutBanner(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	sessionHandler := sharedHandlerFor(t, server.Listener.Addr().String())
+
+	if banner, err := sessionHandler.Peek(); err != nil || len(banner) > 0 {
+		t.Fatalf("Peek() = %q, %v, want no banner", banner, err)
+	}
+	if !discoverProbePhases(sessionHandler, TCP) {
+		t.Fatal("HTTP was not detected in the active phase")
+	}
+}
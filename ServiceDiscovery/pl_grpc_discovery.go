@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// GrpcDiscovery detects gRPC on top of an HTTP/2 connection, lists the services
+// exposed through server reflection and asks grpc.health.v1 for the health status
+type GrpcDiscovery struct {
+}
+
+func (d *GrpcDiscovery) Protocol() PresentationLayerProtocol {
+	return GRPC
+}
+
+func (d *GrpcDiscovery) ProbeOrder() ProbeOrder {
+	return CLIENT_FIRST
+}
+
+type GrpcDiscoveryResult struct {
+	IsDetected bool
+	Properties map[string]interface{}
+}
+
+// GetProperties implements iPresentationDiscoveryResult
+func (hh *GrpcDiscoveryResult) GetProperties() map[string]interface{} {
+	return hh.Properties
+}
+
+// IsDetected implements iPresentationDiscoveryResult
+func (hh *GrpcDiscoveryResult) GetIsDetected() bool {
+	return hh.IsDetected
+}
+
+// Protocol implements iPresentationDiscoveryResult
+func (*GrpcDiscoveryResult) Protocol() PresentationLayerProtocol {
+	return GRPC
+}
+
+// GetServices returns the fully qualified service names listed by reflection
+func (hh *GrpcDiscoveryResult) GetServices() []string {
+	services, _ := hh.Properties["services"].([]string)
+	return services
+}
+
+// gRPC status codes by value
+var grpcStatusNames = []string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND",
+	"ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION",
+	"ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED", "INTERNAL", "UNAVAILABLE", "DATA_LOSS",
+	"UNAUTHENTICATED",
+}
+
+// grpc.health.v1.HealthCheckResponse.ServingStatus by value
+var grpcHealthStatusNames = []string{"UNKNOWN", "SERVING", "NOT_SERVING", "SERVICE_UNKNOWN"}
+
+// Server reflection is tried with the current service name first
+var grpcReflectionPaths = []string{
+	"/grpc.reflection.v1.ServerReflection/ServerReflectionInfo",
+	"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo",
+}
+
+const grpcStatusUnimplemented = 12
+
+func (d *GrpcDiscovery) Discover(sessionHandler iSessionHandler) (iPresentationDiscoveryResult, error) {
+	r := &GrpcDiscoveryResult{
+		IsDetected: false,
+		Properties: make(map[string]interface{}),
+	}
+
+	conn, err := openHttp2Connection(sessionHandler)
+	if err != nil {
+		return r, nil
+	}
+	defer conn.Close()
+
+	// An empty HealthCheckRequest asks for the overall server health
+	health, err := conn.grpcCall(sessionHandler, "/grpc.health.v1.Health/Check", [][]byte{{}})
+	if err != nil || !health.isGrpc() {
+		return r, nil
+	}
+
+	r.IsDetected = true
+	r.Properties["http2Mode"] = conn.mode
+	r.Properties["healthStatus"] = health.statusName()
+	if health.status() == 0 && len(health.messages) > 0 {
+		// HealthCheckResponse.status (field 1)
+		status := protoVarintField(health.messages[0], 1)
+		if status < uint64(len(grpcHealthStatusNames)) {
+			r.Properties["health"] = grpcHealthStatusNames[status]
+		}
+	}
+
+	services, methods, status := grpcReflect(conn, sessionHandler)
+	r.Properties["reflectionStatus"] = status
+	if services != nil {
+		r.Properties["services"] = services
+		r.Properties["methods"] = methods
+	}
+	return r, nil
+}
+
+// grpcResponse holds the headers, trailers and messages of a single gRPC call
+type grpcResponse struct {
+	headers  map[string]string
+	messages [][]byte
+}
+
+// isGrpc tells whether the response was sent by a gRPC server
+func (r *grpcResponse) isGrpc() bool {
+	_, hasStatus := r.headers["grpc-status"]
+	return hasStatus || strings.HasPrefix(r.headers["content-type"], "application/grpc")
+}
+
+// status returns the grpc-status trailer, -1 when it is missing
+func (r *grpcResponse) status() int {
+	status, err := strconv.Atoi(r.headers["grpc-status"])
+	if err != nil {
+		return -1
+	}
+	return status
+}
+
+func (r *grpcResponse) statusName() string {
+	status := r.status()
+	if status < 0 || status >= len(grpcStatusNames) {
+		return r.headers["grpc-status"]
+	}
+	return grpcStatusNames[status]
+}
+
+// grpcCall sends the messages on a new stream, half-closes it and collects
+// everything the server answers until it ends the stream
+func (c *http2Connection) grpcCall(sessionHandler iSessionHandler, path string, messages [][]byte) (*grpcResponse, error) {
+	streamId := c.newStream()
+
+	scheme := "http"
+	if c.mode == HTTP2_MODE_TLS {
+		scheme = "https"
+	}
+	var headerBlock bytes.Buffer
+	encoder := hpack.NewEncoder(&headerBlock)
+	for _, field := range [][2]string{
+		{":method", "POST"},
+		{":scheme", scheme},
+		{":path", path},
+		{":authority", httpHostHeader(sessionHandler)},
+		{"content-type", "application/grpc"},
+		{"te", "trailers"},
+		{"user-agent", "KubeScanner"},
+	} {
+		encoder.WriteField(hpack.HeaderField{Name: field[0], Value: field[1]})
+	}
+	err := c.framer.WriteHeaders(http2.HeadersFrameParam{StreamID: streamId, BlockFragment: headerBlock.Bytes(), EndHeaders: true})
+	if err != nil {
+		return nil, err
+	}
+
+	// Length-prefixed messages: compressed flag and big endian length
+	var data []byte
+	for _, message := range messages {
+		data = append(data, 0)
+		data = binary.BigEndian.AppendUint32(data, uint32(len(message)))
+		data = append(data, message...)
+	}
+	if err := c.framer.WriteData(streamId, true, data); err != nil {
+		return nil, err
+	}
+
+	response := &grpcResponse{headers: make(map[string]string)}
+	var body []byte
+	for {
+		frame, err := c.framer.ReadFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch frame := frame.(type) {
+		case *http2.SettingsFrame:
+			if !frame.IsAck() {
+				c.framer.WriteSettingsAck()
+			}
+		case *http2.PingFrame:
+			if !frame.IsAck() {
+				c.framer.WritePing(true, frame.Data)
+			}
+		case *http2.GoAwayFrame:
+			return nil, fmt.Errorf("server sent GOAWAY %v", frame.ErrCode)
+		case *http2.RSTStreamFrame:
+			if frame.StreamID == streamId {
+				return nil, fmt.Errorf("server reset the stream with %v", frame.ErrCode)
+			}
+		case *http2.MetaHeadersFrame:
+			if frame.StreamID != streamId {
+				continue
+			}
+			for _, field := range frame.Fields {
+				response.headers[field.Name] = field.Value
+			}
+			if frame.StreamEnded() {
+				response.messages = grpcSplitMessages(body)
+				return response, nil
+			}
+		case *http2.DataFrame:
+			if frame.StreamID != streamId {
+				continue
+			}
+			// Give the window back so large responses are not stalled
+			if n := uint32(len(frame.Data())); n > 0 {
+				c.framer.WriteWindowUpdate(0, n)
+				c.framer.WriteWindowUpdate(streamId, n)
+			}
+			if len(body)+len(frame.Data()) > httpMaxBodySize {
+				return nil, errors.New("gRPC response exceeds the size limit")
+			}
+			body = append(body, frame.Data()...)
+			if frame.StreamEnded() {
+				response.messages = grpcSplitMessages(body)
+				return response, nil
+			}
+		}
+	}
+}
+
+// grpcSplitMessages splits a gRPC body into its length-prefixed messages,
+// compressed messages are skipped since no compression was offered
+func grpcSplitMessages(body []byte) [][]byte {
+	var messages [][]byte
+	for len(body) >= 5 {
+		length := int(binary.BigEndian.Uint32(body[1:5]))
+		if 5+length > len(body) {
+			break
+		}
+		if body[0] == 0 {
+			messages = append(messages, body[5:5+length])
+		}
+		body = body[5+length:]
+	}
+	return messages
+}
+
+// grpcReflect lists the services through server reflection and resolves the
+// methods of each service from its file descriptor. It returns the status name
+// of the reflection call, e.g. UNIMPLEMENTED when reflection is not registered.
+func grpcReflect(conn *http2Connection, sessionHandler iSessionHandler) ([]string, map[string][]string, string) {
+	// ServerReflectionRequest.list_services (field 7)
+	listRequest := protoAppendBytes(nil, 7, []byte("*"))
+
+	status := ""
+	for _, path := range grpcReflectionPaths {
+		response, err := conn.grpcCall(sessionHandler, path, [][]byte{listRequest})
+		if err != nil {
+			return nil, nil, err.Error()
+		}
+		status = response.statusName()
+		if response.status() == grpcStatusUnimplemented || len(response.messages) == 0 {
+			continue
+		}
+
+		// ServerReflectionResponse.list_services_response (6) -> service (1) -> name (1)
+		var services []string
+		for _, message := range response.messages {
+			for _, listResponse := range protoBytesFields(message, 6) {
+				for _, service := range protoBytesFields(listResponse, 1) {
+					for _, name := range protoBytesFields(service, 1) {
+						services = append(services, string(name))
+					}
+				}
+			}
+		}
+
+		// ServerReflectionRequest.file_containing_symbol (field 4) per service
+		var symbolRequests [][]byte
+		for _, service := range services {
+			symbolRequests = append(symbolRequests, protoAppendBytes(nil, 4, []byte(service)))
+		}
+		methods := make(map[string][]string)
+		if len(symbolRequests) > 0 {
+			response, err := conn.grpcCall(sessionHandler, path, symbolRequests)
+			if err == nil {
+				for _, message := range response.messages {
+					// file_descriptor_response (4) -> file_descriptor_proto (1)
+					for _, fileResponse := range protoBytesFields(message, 4) {
+						for _, file := range protoBytesFields(fileResponse, 1) {
+							grpcFileMethods(file, methods)
+						}
+					}
+				}
+			}
+		}
+		return services, methods, status
+	}
+	return nil, nil, status
+}
+
+// grpcFileMethods adds the methods of every service in a FileDescriptorProto
+func grpcFileMethods(file []byte, methods map[string][]string) {
+	// FileDescriptorProto: package (2), service (6) -> name (1), method (2) -> name (1)
+	pkg := ""
+	for _, name := range protoBytesFields(file, 2) {
+		pkg = string(name) + "."
+	}
+	for _, service := range protoBytesFields(file, 6) {
+		serviceName := ""
+		for _, name := range protoBytesFields(service, 1) {
+			serviceName = pkg + string(name)
+		}
+		if _, seen := methods[serviceName]; seen {
+			continue
+		}
+		methods[serviceName] = []string{}
+		for _, method := range protoBytesFields(service, 2) {
+			for _, name := range protoBytesFields(method, 1) {
+				methods[serviceName] = append(methods[serviceName], string(name))
+			}
+		}
+	}
+}
+
+// protoField is a single decoded field of a protobuf message
+type protoField struct {
+	number   int
+	wireType int
+	varint   uint64
+	bytes    []byte
+}
+
+// protoDecode splits a protobuf message into its fields without a schema
+func protoDecode(data []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return fields, errors.New("invalid protobuf field key")
+		}
+		data = data[n:]
+		field := protoField{number: int(key >> 3), wireType: int(key & 7)}
+
+		switch field.wireType {
+		case 0:
+			field.varint, n = binary.Uvarint(data)
+			if n <= 0 {
+				return fields, errors.New("invalid protobuf varint")
+			}
+			data = data[n:]
+		case 1, 5:
+			size := 8
+			if field.wireType == 5 {
+				size = 4
+			}
+			if len(data) < size {
+				return fields, errors.New("truncated protobuf fixed field")
+			}
+			field.bytes = data[:size]
+			data = data[size:]
+		case 2:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return fields, errors.New("truncated protobuf length-delimited field")
+			}
+			field.bytes = data[n : n+int(length)]
+			data = data[n+int(length):]
+		default:
+			return fields, fmt.Errorf("unsupported protobuf wire type %d", field.wireType)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// protoBytesFields returns the values of all length-delimited fields with the number
+func protoBytesFields(data []byte, number int) [][]byte {
+	fields, _ := protoDecode(data)
+	var values [][]byte
+	for _, field := range fields {
+		if field.number == number && field.wireType == 2 {
+			values = append(values, field.bytes)
+		}
+	}
+	return values
+}
+
+// protoVarintField returns the value of the last varint field with the number
+func protoVarintField(data []byte, number int) uint64 {
+	fields, _ := protoDecode(data)
+	value := uint64(0)
+	for _, field := range fields {
+		if field.number == number && field.wireType == 0 {
+			value = field.varint
+		}
+	}
+	return value
+}
+
+// protoAppendBytes appends a length-delimited field (strings, bytes and messages)
+func protoAppendBytes(data []byte, number int, value []byte) []byte {
+	data = binary.AppendUvarint(data, uint64(number)<<3|2)
+	data = binary.AppendUvarint(data, uint64(len(value)))
+	return append(data, value...)
+}
+
+// protoAppendVarint appends a varint field (integers, booleans and enums)
+func protoAppendVarint(data []byte, number int, value uint64) []byte {
+	data = binary.AppendUvarint(data, uint64(number)<<3)
+	return binary.AppendUvarint(data, value)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestProtoRoundTrip(t *testing.T) {
+	message := protoAppendBytes(nil, 1, []byte("etcd"))
+	message = protoAppendVarint(message, 3, 300)
+	message = protoAppendBytes(message, 2, protoAppendVarint(nil, 1, 1))
+	message = protoAppendBytes(message, 1, []byte("second"))
+	message = protoAppendVarint(message, 3, 1<<40)
+
+	// Field 1 "etcd": key 0x0a, length 4; field 3 300: key 0x18, varint 0xac 0x02
+	if !bytes.HasPrefix(message, []byte{0x0a, 0x04, 'e', 't', 'c', 'd', 0x18, 0xac, 0x02}) {
+		t.Errorf("encoding = % x", message)
+	}
+	if got := protoBytesFields(message, 1); !reflect.DeepEqual(got, [][]byte{[]byte("etcd"), []byte("second")}) {
+		t.Errorf("protoBytesFields(1) = %q", got)
+	}
+	if got := protoVarintField(message, 3); got != 1<<40 {
+		t.Errorf("protoVarintField(3) = %d, want the last value %d", got, uint64(1)<<40)
+	}
+	nested := protoBytesFields(message, 2)
+	if len(nested) != 1 || protoVarintField(nested[0], 1) != 1 {
+		t.Errorf("nested message = % x", nested)
+	}
+	if got := protoVarintField(message, 9); got != 0 {
+		t.Errorf("missing field = %d, want 0", got)
+	}
+}
+
+func TestProtoDecodeMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated key", []byte{0x80}},
+		{"truncated varint", []byte{0x08, 0xff}},
+		{"length beyond data", []byte{0x0a, 0x05, 'a'}},
+		{"huge length", []byte{0x0a, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}},
+		{"truncated fixed64", []byte{0x09, 0x01, 0x02}},
+		{"group wire type", []byte{0x0b}},
+	}
+	for _, test := range tests {
+		if _, err := protoDecode(test.data); err == nil {
+			t.Errorf("protoDecode(%s) succeeded", test.name)
+		}
+	}
+
+	// Fields before the malformed one are still returned
+	data := append(protoAppendVarint(nil, 1, 7), 0x0a, 0x05)
+	fields, err := protoDecode(data)
+	if err == nil || len(fields) != 1 || fields[0].varint != 7 {
+		t.Errorf("protoDecode = %+v, %v", fields, err)
+	}
+}
+
+func TestGrpcSplitMessages(t *testing.T) {
+	body := []byte{
+		0, 0, 0, 0, 2, 'h', 'i', // message "hi"
+		1, 0, 0, 0, 1, 'z', // compressed, skipped
+		0, 0, 0, 0, 0, // empty message
+		0, 0, 0, 0, 9, 'c', 'u', 't', // truncated
+	}
+	got := grpcSplitMessages(body)
+	want := [][]byte{[]byte("hi"), {}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("grpcSplitMessages = %q, want %q", got, want)
+	}
+}
+
+func TestGrpcResponseStatus(t *testing.T) {
+	tests := []struct {
+		headers map[string]string
+		grpc    bool
+		status  int
+		name    string
+	}{
+		{map[string]string{"grpc-status": "0"}, true, 0, "OK"},
+		{map[string]string{"grpc-status": "12", "content-type": "application/grpc"}, true, 12, "UNIMPLEMENTED"},
+		{map[string]string{"grpc-status": "99"}, true, 99, "99"},
+		{map[string]string{"content-type": "application/grpc+proto"}, true, -1, ""},
+		{map[string]string{"content-type": "text/html"}, false, -1, ""},
+	}
+	for _, test := range tests {
+		response := &grpcResponse{headers: test.headers}
+		if response.isGrpc() != test.grpc || response.status() != test.status || response.statusName() != test.name {
+			t.Errorf("%v: isGrpc=%v status=%d name=%q", test.headers, response.isGrpc(), response.status(), response.statusName())
+		}
+	}
+}
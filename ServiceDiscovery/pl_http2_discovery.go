@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// Http2Discovery detects HTTP/2 over TLS (ALPN h2) and over cleartext (h2c),
+// either with prior knowledge or with an HTTP/1.1 Upgrade
+type Http2Discovery struct {
+}
+
+func (d *Http2Discovery) Protocol() PresentationLayerProtocol {
+	return HTTP2
+}
+
+func (d *Http2Discovery) ProbeOrder() ProbeOrder {
+	return CLIENT_FIRST
+}
+
+type Http2DiscoveryResult struct {
+	IsDetected bool
+	Properties map[string]interface{}
+}
+
+// GetProperties implements iPresentationDiscoveryResult
+func (hh *Http2DiscoveryResult) GetProperties() map[string]interface{} {
+	return hh.Properties
+}
+
+// IsDetected implements iPresentationDiscoveryResult
+func (hh *Http2DiscoveryResult) GetIsDetected() bool {
+	return hh.IsDetected
+}
+
+// Protocol implements iPresentationDiscoveryResult
+func (*Http2DiscoveryResult) Protocol() PresentationLayerProtocol {
+	return HTTP2
+}
+
+// HTTP/2 modes reported by the discovery
+const (
+	HTTP2_MODE_TLS             = "h2"
+	HTTP2_MODE_PRIOR_KNOWLEDGE = "h2c-prior-knowledge"
+	HTTP2_MODE_UPGRADE         = "h2c-upgrade"
+)
+
+func (d *Http2Discovery) Discover(sessionHandler iSessionHandler) (iPresentationDiscoveryResult, error) {
+	r := &Http2DiscoveryResult{
+		IsDetected: false,
+		Properties: make(map[string]interface{}),
+	}
+
+	var modes []string
+	conn, err := openHttp2Connection(sessionHandler)
+	if err == nil {
+		conn.Close()
+		modes = append(modes, conn.mode)
+		r.Properties["settings"] = conn.settings
+	}
+
+	// The Upgrade dance only exists for cleartext connections
+	if _, isTls := unwrapSessionHandler(sessionHandler).(*TlsSessionHandler); !isTls {
+		settings, err := http2UpgradeSettings(sessionHandler)
+		if err == nil {
+			modes = append(modes, HTTP2_MODE_UPGRADE)
+			if _, ok := r.Properties["settings"]; !ok {
+				r.Properties["settings"] = settings
+			}
+		}
+	}
+
+	if len(modes) == 0 {
+		return r, nil
+	}
+
+	// Servers negotiating h2 may still answer plain HTTP/1.1 requests
+	_, err = httpProbePath(sessionHandler, "/")
+	r.IsDetected = true
+	r.Properties["modes"] = modes
+	r.Properties["http11Accepted"] = err == nil
+	return r, nil
+}
+
+// http2Connection is an HTTP/2 connection after the preface and SETTINGS exchange
+type http2Connection struct {
+	handler iSessionHandler
+	framer  *http2.Framer
+	mode    string
+	// settings holds the values of the server SETTINGS frame by name
+	settings     map[string]uint32
+	nextStreamId uint32
+}
+
+// openHttp2Connection opens a connection of its own over TLS with ALPN h2 or
+// over cleartext with prior knowledge, and exchanges the connection preface
+func openHttp2Connection(sessionHandler iSessionHandler) (*http2Connection, error) {
+	handler := sessionHandler
+	mode := HTTP2_MODE_PRIOR_KNOWLEDGE
+	if tlsHandler, ok := unwrapSessionHandler(sessionHandler).(*TlsSessionHandler); ok {
+		// A fresh TLS connection is needed to offer the h2 ALPN protocol
+		handler = &TlsSessionHandler{host: tlsHandler.GetHost(), port: tlsHandler.GetPort(), nextProtos: []string{http2.NextProtoTLS}}
+		mode = HTTP2_MODE_TLS
+	}
+
+	if err := handler.Connect(); err != nil {
+		return nil, err
+	}
+	if tlsHandler, ok := handler.(*TlsSessionHandler); ok && tlsHandler.GetAlpn() != http2.NextProtoTLS {
+		handler.Destory()
+		return nil, errors.New("server did not negotiate h2")
+	}
+
+	conn := &http2Connection{
+		handler: handler,
+		framer:  http2.NewFramer(handler, handler),
+		mode:    mode,
+		// Client initiated streams are odd numbered
+		nextStreamId: 1,
+	}
+	conn.framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	handler.SetReadDeadline(time.Now().Add(httpTimeout))
+	if _, err := io.WriteString(handler, http2.ClientPreface); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.framer.WriteSettings(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	settings, err := readHttp2Settings(conn.framer)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.settings = settings
+	if err := conn.framer.WriteSettingsAck(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// newStream returns the identifier of the next client stream
+func (c *http2Connection) newStream() uint32 {
+	streamId := c.nextStreamId
+	c.nextStreamId += 2
+	return streamId
+}
+
+func (c *http2Connection) Close() error {
+	c.framer.WriteGoAway(0, http2.ErrCodeNo, nil)
+	return c.handler.Destory()
+}
+
+// readHttp2Settings reads the SETTINGS frame a server must send first
+func readHttp2Settings(framer *http2.Framer) (map[string]uint32, error) {
+	frame, err := framer.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	settingsFrame, ok := frame.(*http2.SettingsFrame)
+	if !ok || settingsFrame.IsAck() {
+		return nil, fmt.Errorf("expected SETTINGS frame, got %v", frame.Header().Type)
+	}
+
+	settings := make(map[string]uint32)
+	settingsFrame.ForeachSetting(func(setting http2.Setting) error {
+		settings[setting.ID.String()] = setting.Val
+		return nil
+	})
+	return settings, nil
+}
+
+// http2UpgradeSettings asks an HTTP/1.1 server to switch to h2c and returns
+// the SETTINGS of the server after the 101 response
+func http2UpgradeSettings(sessionHandler iSessionHandler) (map[string]uint32, error) {
+	resp, reader, err := httpUpgrade(sessionHandler, "/", http.Header{
+		"Connection": {"Upgrade, HTTP2-Settings"},
+		"Upgrade":    {"h2c"},
+		// An empty SETTINGS payload keeps all defaults
+		"HTTP2-Settings": {base64.RawURLEncoding.EncodeToString(nil)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("h2c upgrade refused with %s", resp.Status)
+	}
+	defer sessionHandler.Destory()
+
+	// The client preface still has to be sent after the upgrade
+	framer := http2.NewFramer(sessionHandler, reader)
+	if _, err := io.WriteString(sessionHandler, http2.ClientPreface); err != nil {
+		return nil, err
+	}
+	if err := framer.WriteSettings(); err != nil {
+		return nil, err
+	}
+	return readHttp2Settings(framer)
+}
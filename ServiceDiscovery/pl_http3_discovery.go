@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// Http3Discovery detects HTTP/3 on a QUIC session which negotiated an h3 ALPN
+type Http3Discovery struct {
+}
+
+func (d *Http3Discovery) Protocol() PresentationLayerProtocol {
+	return HTTP3
+}
+
+func (d *Http3Discovery) ProbeOrder() ProbeOrder {
+	return CLIENT_FIRST
+}
+
+type Http3DiscoveryResult struct {
+	IsDetected bool
+	Properties map[string]interface{}
+}
+
+// GetProperties implements iPresentationDiscoveryResult
+func (hh *Http3DiscoveryResult) GetProperties() map[string]interface{} {
+	return hh.Properties
+}
+
+// IsDetected implements iPresentationDiscoveryResult
+func (hh *Http3DiscoveryResult) GetIsDetected() bool {
+	return hh.IsDetected
+}
+
+// Protocol implements iPresentationDiscoveryResult
+func (*Http3DiscoveryResult) Protocol() PresentationLayerProtocol {
+	return HTTP3
+}
+
+func (d *Http3Discovery) Discover(sessionHandler iSessionHandler) (iPresentationDiscoveryResult, error) {
+	quicHandler, ok := unwrapSessionHandler(sessionHandler).(*QuicSessionHandler)
+	if !ok || !strings.HasPrefix(quicHandler.GetAlpn(), "h3") {
+		return &Http3DiscoveryResult{IsDetected: false}, nil
+	}
+
+	// HTTP/3 needs its own control and QPACK streams, so the request is sent by
+	// the http3 transport on a connection of its own
+	transport := &http3.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			NextProtos:         []string{quicHandler.GetAlpn()},
+		},
+		Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+			cfg.HandshakeIdleTimeout = quicHandshakeTimeout
+			return quic.DialAddr(ctx, addr, tlsCfg, cfg)
+		},
+	}
+	defer transport.Close()
+
+	url := fmt.Sprintf("https://%s/", net.JoinHostPort(sessionHandler.GetHost(), strconv.Itoa(sessionHandler.GetPort())))
+	client := &http.Client{Transport: transport, Timeout: quicHandshakeTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	return &Http3DiscoveryResult{
+		IsDetected: true,
+		Properties: map[string]interface{}{
+			"version":    resp.Proto,
+			"alpn":       quicHandler.GetAlpn(),
+			"statusCode": resp.StatusCode,
+			"server":     resp.Header.Get("Server"),
+			"altSvc":     resp.Header.Get("Alt-Svc"),
+		},
+	}, nil
+}
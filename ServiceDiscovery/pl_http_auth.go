@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Access control categories of an HTTP endpoint
+const (
+	HTTP_AUTH_OPEN          = "open"
+	HTTP_AUTH_BASIC         = "basic"
+	HTTP_AUTH_DIGEST        = "digest"
+	HTTP_AUTH_BEARER        = "bearer"
+	HTTP_AUTH_NEGOTIATE     = "negotiate"
+	HTTP_AUTH_OIDC_REDIRECT = "oidc-redirect"
+	HTTP_AUTH_LOGIN_FORM    = "login-form"
+	HTTP_AUTH_CLIENT_CERT   = "client-cert"
+	HTTP_AUTH_FORBIDDEN     = "forbidden"
+	// HTTP_AUTH_AUTHENTICATED is a response to a request carrying the -cert
+	// client certificate, it tells nothing about anonymous access
+	HTTP_AUTH_AUTHENTICATED = "authenticated"
+	// HTTP_AUTH_UNAUTHORIZED is a 401 response without a known challenge
+	HTTP_AUTH_UNAUTHORIZED = "unauthorized"
+	HTTP_AUTH_UNKNOWN      = "unknown"
+)
+
+// httpMaxRedirects bounds the redirects followed within one endpoint
+const httpMaxRedirects = 5
+
+// HttpAuthClassification describes the access control in front of a path
+type HttpAuthClassification struct {
+	Path     string
+	Category string
+	// StatusCode of the last response, 0 when no response was received
+	StatusCode int
+	// Realm of a Basic or Digest challenge
+	Realm string
+	// Issuer of an OIDC/OAuth authorization redirect
+	Issuer    string
+	Redirects []string
+	// FinalPath is the last path requested after following redirects
+	FinalPath string
+}
+
+// IsAuthRequired tells whether an anonymous client is kept out of the path, a
+// path served to our client certificate is not counted as anonymous
+func (c *HttpAuthClassification) IsAuthRequired() bool {
+	return c != nil && c.Category != HTTP_AUTH_OPEN && c.Category != HTTP_AUTH_UNKNOWN
+}
+
+// Properties returns the classification for discovery properties
+func (c *HttpAuthClassification) Properties() map[string]interface{} {
+	properties := map[string]interface{}{
+		"path":       c.Path,
+		"category":   c.Category,
+		"statusCode": c.StatusCode,
+	}
+	if c.Realm != "" {
+		properties["realm"] = c.Realm
+	}
+	if c.Issuer != "" {
+		properties["issuer"] = c.Issuer
+	}
+	if len(c.Redirects) > 0 {
+		properties["redirects"] = c.Redirects
+	}
+	return properties
+}
+
+// Path fragments of OIDC/OAuth authorization endpoints and of login proxies
+var httpOauthPathMarkers = []string{
+	"/protocol/openid-connect/", // Keycloak
+	"/oauth2/authorize",
+	"/oauth2/v2.0/authorize", // Azure AD
+	"/oauth2/auth",
+	"/o/oauth2/",
+	"/oauth/authorize",
+	"/authorize",
+	"/dex/auth",
+	"/oauth2/start", // oauth2-proxy
+	"/oauth2/sign_in",
+	"/sso/",
+}
+
+// httpClassifyAuth requests the path anonymously, or with the -cert client
+// certificate when the server asks for one, follows redirects within the
+// endpoint and classifies the access control of the final response
+func httpClassifyAuth(sessionHandler iSessionHandler, path string) *HttpAuthClassification {
+	classification := &HttpAuthClassification{Path: path, Category: HTTP_AUTH_UNKNOWN}
+
+	current := path
+	for hop := 0; ; hop++ {
+		classification.FinalPath = current
+		resp, err := httpProbePath(sessionHandler, current)
+		if err != nil {
+			// A TLS alert after a certificate request asks for a client certificate
+			if errors.Is(err, errTlsClientCertificateRequired) {
+				classification.Category = HTTP_AUTH_CLIENT_CERT
+			}
+			return classification
+		}
+		classification.StatusCode = resp.StatusCode
+
+		switch {
+		case resp.StatusCode == 401:
+			classification.Category, classification.Realm = httpClassifyChallenge(resp.Header.Values("Www-Authenticate"))
+			return classification
+		case resp.StatusCode == 403:
+			classification.Category = HTTP_AUTH_FORBIDDEN
+			return classification
+		case resp.StatusCode >= 300 && resp.StatusCode < 400 && resp.Header.Get("Location") != "":
+			location := resp.Header.Get("Location")
+			classification.Redirects = append(classification.Redirects, location)
+			if issuer, ok := httpOauthIssuer(location); ok {
+				classification.Category = HTTP_AUTH_OIDC_REDIRECT
+				classification.Issuer = issuer
+				return classification
+			}
+			next, sameEndpoint := httpRedirectPath(sessionHandler, current, location)
+			if !sameEndpoint || hop >= httpMaxRedirects {
+				// Redirects to other hosts are not followed
+				return classification
+			}
+			current = next
+		default:
+			classification.Category = HTTP_AUTH_OPEN
+			if resp.StatusCode == 200 && httpIsLoginPage(current, resp) {
+				classification.Category = HTTP_AUTH_LOGIN_FORM
+			} else if sessionClientCertificateSent(sessionHandler) {
+				classification.Category = HTTP_AUTH_AUTHENTICATED
+			}
+			return classification
+		}
+	}
+}
+
+// httpClassifyChallenge returns the category and realm of WWW-Authenticate challenges
+func httpClassifyChallenge(challenges []string) (string, string) {
+	category := HTTP_AUTH_UNAUTHORIZED
+	realm := ""
+	for _, challenge := range challenges {
+		scheme := strings.ToLower(strings.Fields(challenge + " ")[0])
+		if index := strings.Index(strings.ToLower(challenge), "realm=\""); index >= 0 {
+			rest := challenge[index+len("realm=\""):]
+			if end := strings.Index(rest, "\""); end >= 0 {
+				realm = rest[:end]
+			}
+		}
+		switch scheme {
+		case "basic":
+			return HTTP_AUTH_BASIC, realm
+		case "digest":
+			return HTTP_AUTH_DIGEST, realm
+		case "bearer":
+			category = HTTP_AUTH_BEARER
+		case "negotiate", "ntlm":
+			category = HTTP_AUTH_NEGOTIATE
+		}
+	}
+	return category, realm
+}
+
+// httpOauthIssuer recognizes an OIDC/OAuth authorization redirect and returns
+// the issuer, i.e. the URL up to the authorization endpoint
+func httpOauthIssuer(location string) (string, bool) {
+	target, err := url.Parse(location)
+	if err != nil {
+		return "", false
+	}
+	query := target.Query()
+	isAuthorize := query.Get("client_id") != "" && (query.Get("response_type") != "" || query.Get("redirect_uri") != "")
+
+	for _, marker := range httpOauthPathMarkers {
+		index := strings.Index(target.Path, marker)
+		if index < 0 {
+			continue
+		}
+		if !isAuthorize && target.Host == "" && marker != "/oauth2/start" && marker != "/oauth2/sign_in" {
+			continue
+		}
+		// e.g. Keycloak issuers end with the realm path before /protocol/openid-connect/
+		issuer := target.Path[:index]
+		if target.Host != "" {
+			issuer = target.Scheme + "://" + target.Host + issuer
+		}
+		return issuer, true
+	}
+	if isAuthorize {
+		return target.Scheme + "://" + target.Host, true
+	}
+	return "", false
+}
+
+// httpRedirectPath resolves a Location header against the current path and
+// tells whether it stays on the same endpoint
+func httpRedirectPath(sessionHandler iSessionHandler, current string, location string) (string, bool) {
+	base, err := url.Parse(current)
+	if err != nil {
+		return "", false
+	}
+	target, err := url.Parse(location)
+	if err != nil {
+		return "", false
+	}
+	resolved := base.ResolveReference(target)
+
+	if target.Host != "" {
+		host, port, err := net.SplitHostPort(target.Host)
+		if err != nil {
+			host = target.Host
+			port = "80"
+			if target.Scheme == "https" {
+				port = "443"
+			}
+		}
+		if host != sessionHandler.GetHost() || port != strconv.Itoa(sessionHandler.GetPort()) {
+			return "", false
+		}
+	}
+	return resolved.RequestURI(), true
+}
+
+// httpIsLoginPage tells whether a page asks for credentials in a form
+func httpIsLoginPage(path string, resp *HttpResponse) bool {
+	lowerPath := strings.ToLower(path)
+	if strings.Contains(lowerPath, "login") || strings.Contains(lowerPath, "signin") || strings.Contains(lowerPath, "sign_in") {
+		return true
+	}
+	body := strings.ToLower(string(resp.Body))
+	return strings.Contains(body, `type="password"`) || strings.Contains(body, `type='password'`)
+}
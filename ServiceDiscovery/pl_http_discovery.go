@@ -1,8 +1,15 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"html"
+	"io"
+	"net/http"
 	"regexp"
+	"strings"
+	"time"
 )
 
 // For HTTP example implementation of the PresentationLayerDiscovery interface
@@ -13,15 +20,8 @@ func (d *HttpDiscovery) Protocol() PresentationLayerProtocol {
 	return HTTP
 }
 
-type SessionHandler struct {
-	IP                string
-	Port              string
-	TransportProtocol string
-}
-
-func (s *SessionHandler) Connect() error {
-	// Connect to IP:Port
-	return nil
+func (d *HttpDiscovery) ProbeOrder() ProbeOrder {
+	return CLIENT_FIRST
 }
 
 type HttpDiscoveryResult struct {
@@ -45,41 +45,222 @@ func (*HttpDiscoveryResult) Protocol() PresentationLayerProtocol {
 }
 
 func (d *HttpDiscovery) Discover(sessionHandler iSessionHandler) (iPresentationDiscoveryResult, error) {
-	// Connect to sessionHandler
+	r := &HttpDiscoveryResult{
+		IsDetected: false,
+		Properties: make(map[string]interface{}),
+	}
+
+	// Anything that can not be parsed as an HTTP/1.x response is not HTTP
+	resp, err := httpProbePath(sessionHandler, "/")
+	if err != nil {
+		if _, ok := err.(*httpParseError); ok {
+			return r, nil
+		}
+		return nil, err
+	}
+
+	r.IsDetected = true
+	r.Properties = resp.Properties()
+
+	// Classify the access control in front of the root page
+	r.Properties["auth"] = httpClassifyAuth(sessionHandler, "/").Properties()
+
+	// Hash the favicon so web UIs can be fingerprinted
+	if favicon := httpFavicon(sessionHandler, resp); favicon != nil {
+		r.Properties["faviconPath"] = favicon.Path
+		r.Properties["faviconMmh3"] = favicon.Mmh3
+		r.Properties["faviconSha256"] = favicon.Sha256
+	}
+
+	// Fetch the well-known paths once for all application detectors
+	httpProbePaths(sessionHandler)
+	r.Properties["paths"] = httpPathEvidence(sessionHandler)
+	return r, nil
+}
+
+// httpTimeout bounds a single request sent by httpRequest
+const httpTimeout = 5 * time.Second
+
+// httpMaxBodySize bounds the response bodies read by httpRequest
+const httpMaxBodySize = 1 << 20
+
+// httpBodyPropertyLimit bounds the body reported in the discovery properties
+const httpBodyPropertyLimit = 4096
+
+// HttpResponse is a fully parsed HTTP/1.x response
+type HttpResponse struct {
+	Version    string
+	StatusLine string
+	StatusCode int
+	Header     http.Header
+	// RawHeader is the status line and header block as received
+	RawHeader string
+	// Body is decoded from chunked encoding and bounded by httpMaxBodySize
+	Body  []byte
+	Title string
+	// Generator is the content of the HTML generator meta tag
+	Generator string
+}
+
+// httpParseError is returned when the server answered with something other than HTTP
+type httpParseError struct {
+	err error
+}
+
+func (e *httpParseError) Error() string {
+	return "not an HTTP response: " + e.err.Error()
+}
+
+var httpTitleRegexp = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// The generator meta tag may list its attributes in either order
+var httpGeneratorRegexps = []*regexp.Regexp{
+	regexp.MustCompile(`(?is)<meta[^>]+name=["']generator["'][^>]*content=["']([^"']*)["']`),
+	regexp.MustCompile(`(?is)<meta[^>]+content=["']([^"']*)["'][^>]*name=["']generator["']`),
+}
+
+// Properties returns the response fields application detectors rely on
+func (r *HttpResponse) Properties() map[string]interface{} {
+	headers := make(map[string]string, len(r.Header))
+	for name, values := range r.Header {
+		headers[name] = strings.Join(values, ", ")
+	}
+
+	body := r.Body
+	if len(body) > httpBodyPropertyLimit {
+		body = body[:httpBodyPropertyLimit]
+	}
+
+	return map[string]interface{}{
+		"version":         r.Version,
+		"statusLine":      r.StatusLine,
+		"statusCode":      r.StatusCode,
+		"headers":         headers,
+		"header":          r.RawHeader,
+		"body":            string(body),
+		"title":           r.Title,
+		"generator":       r.Generator,
+		"server":          r.Header.Get("Server"),
+		"wwwAuthenticate": r.Header.Get("Www-Authenticate"),
+		"contentType":     r.Header.Get("Content-Type"),
+	}
+}
+
+// httpHostHeader returns the Host header value for requests over the session,
+// sockets have no host name so "localhost" is used like curl --unix-socket does
+func httpHostHeader(sessionHandler iSessionHandler) string {
+	if _, ok := unwrapSessionHandler(sessionHandler).(*UnixSessionHandler); ok {
+		return "localhost"
+	}
+	return sessionHandler.GetHost()
+}
+
+// httpGet sends a GET request for path over its own connection of the session
+func httpGet(sessionHandler iSessionHandler, path string) (*HttpResponse, error) {
+	return httpRequest(sessionHandler, http.MethodGet, path, nil, nil)
+}
+
+// httpRequest sends a request over its own connection of the session and parses
+// the response, handling Content-Length, chunked encoding and connection close
+func httpRequest(sessionHandler iSessionHandler, method string, path string, headers map[string]string, body []byte) (*HttpResponse, error) {
 	err := sessionHandler.Connect()
 	if err != nil {
 		return nil, err
 	}
 	defer sessionHandler.Destory()
 
-	// Try to write an HTTP request to sessionHandler
-	_, err = sessionHandler.Write([]byte(fmt.Sprintf("GET / HTTP/1.1\r\nHost: %s\r\n\r\n", sessionHandler.GetHost())))
-	if err != nil {
+	request := fmt.Sprintf("%s %s HTTP/1.1\r\nHost: %s\r\nUser-Agent: KubeScanner\r\nAccept: */*\r\nConnection: close\r\n", method, path, httpHostHeader(sessionHandler))
+	for name, value := range headers {
+		request += name + ": " + value + "\r\n"
+	}
+	if body != nil {
+		request += fmt.Sprintf("Content-Length: %d\r\n", len(body))
+	}
+	request += "\r\n"
+	if _, err := sessionHandler.Write(append([]byte(request), body...)); err != nil {
 		return nil, err
 	}
 
-	// Read response from sessionHandler
-	headerBuf := make([]byte, 1024)
-	_, err = sessionHandler.Read(headerBuf)
+	// Record everything read so the raw header block can be reported
+	var raw bytes.Buffer
+	sessionHandler.SetReadDeadline(time.Now().Add(httpTimeout))
+	resp, err := http.ReadResponse(bufio.NewReader(io.TeeReader(sessionHandler, &raw)), &http.Request{Method: method})
 	if err != nil {
+		if raw.Len() > 0 {
+			return nil, &httpParseError{err: err}
+		}
 		return nil, err
 	}
+	defer resp.Body.Close()
 
-	r := &HttpDiscoveryResult{
-		IsDetected: false,
-		Properties: make(map[string]interface{}),
+	// A body cut short by the deadline is still worth returning
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, httpMaxBodySize))
+	if err != nil && len(respBody) == 0 && err != io.ErrUnexpectedEOF {
+		return nil, err
 	}
 
-	// Write regexp to parse HTTP response header and extract version
-	re := regexp.MustCompile(`HTTP\/(\d+\.\d+) \d+ .+\r\n`)
-	match := re.FindSubmatch(headerBuf)
-	if match != nil && len(match) > 1 {
-		r.IsDetected = true
-		r.Properties["version"] = string(match[1])
-		// we should return all important header fields
-	} else {
-		r.IsDetected = false
+	rawHeader := raw.String()
+	if end := strings.Index(rawHeader, "\r\n\r\n"); end >= 0 {
+		rawHeader = rawHeader[:end]
+	}
+	statusLine := rawHeader
+	if end := strings.Index(statusLine, "\r\n"); end >= 0 {
+		statusLine = statusLine[:end]
 	}
 
+	r := &HttpResponse{
+		Version:    fmt.Sprintf("%d.%d", resp.ProtoMajor, resp.ProtoMinor),
+		StatusLine: statusLine,
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		RawHeader:  rawHeader,
+		Body:       respBody,
+	}
+	if match := httpTitleRegexp.FindSubmatch(respBody); match != nil {
+		r.Title = strings.TrimSpace(html.UnescapeString(string(match[1])))
+	}
+	for _, generatorRegexp := range httpGeneratorRegexps {
+		if match := generatorRegexp.FindSubmatch(respBody); match != nil {
+			r.Generator = strings.TrimSpace(html.UnescapeString(string(match[1])))
+			break
+		}
+	}
 	return r, nil
 }
+
+// httpUpgrade sends a GET request asking to switch protocols and parses the
+// response. On a 101 response the connection is left open for the caller to
+// continue on the returned reader and to Destory, otherwise it is closed.
+func httpUpgrade(sessionHandler iSessionHandler, path string, headers http.Header) (*http.Response, *bufio.Reader, error) {
+	err := sessionHandler.Connect()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	request := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\nUser-Agent: KubeScanner\r\n", path, httpHostHeader(sessionHandler))
+	for name, values := range headers {
+		// Repeated headers are sent on separate lines, e.g. X-Stream-Protocol-Version
+		for _, value := range values {
+			request += name + ": " + value + "\r\n"
+		}
+	}
+	request += "\r\n"
+	if _, err := sessionHandler.Write([]byte(request)); err != nil {
+		sessionHandler.Destory()
+		return nil, nil, err
+	}
+
+	sessionHandler.SetReadDeadline(time.Now().Add(httpTimeout))
+	reader := bufio.NewReader(sessionHandler)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodGet})
+	if err != nil {
+		sessionHandler.Destory()
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, httpMaxBodySize))
+		resp.Body.Close()
+		sessionHandler.Destory()
+	}
+	return resp, reader, nil
+}
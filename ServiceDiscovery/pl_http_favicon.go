@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"math/bits"
+	"regexp"
+	"strings"
+)
+
+// HttpFavicon holds the hashes of the favicon of an HTTP endpoint
+type HttpFavicon struct {
+	Path string
+	// Mmh3 is the MurmurHash3 of the base64 encoded icon as used by Shodan
+	Mmh3   int32
+	Sha256 string
+}
+
+// The icon link may list its attributes in either order
+var httpIconLinkRegexps = []*regexp.Regexp{
+	regexp.MustCompile(`(?is)<link[^>]+rel=["'](?:shortcut )?icon["'][^>]*href=["']([^"']+)["']`),
+	regexp.MustCompile(`(?is)<link[^>]+href=["']([^"']+)["'][^>]*rel=["'](?:shortcut )?icon["']`),
+}
+
+// httpFavicon fetches the icon linked from the root page, or /favicon.ico, and
+// hashes it. Icons hosted on other origins are not fetched.
+func httpFavicon(sessionHandler iSessionHandler, root *HttpResponse) *HttpFavicon {
+	paths := []string{}
+	for _, iconLinkRegexp := range httpIconLinkRegexps {
+		if match := iconLinkRegexp.FindSubmatch(root.Body); match != nil {
+			href := string(match[1])
+			if strings.HasPrefix(href, "/") && !strings.HasPrefix(href, "//") {
+				paths = append(paths, href)
+			} else if !strings.Contains(href, ":") && !strings.HasPrefix(href, "//") {
+				paths = append(paths, "/"+strings.TrimPrefix(href, "./"))
+			}
+			break
+		}
+	}
+	paths = append(paths, "/favicon.ico")
+
+	for _, path := range paths {
+		resp, err := httpProbePath(sessionHandler, path)
+		if err != nil || resp.StatusCode != 200 || len(resp.Body) == 0 || strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+			continue
+		}
+		sum := sha256.Sum256(resp.Body)
+		return &HttpFavicon{
+			Path:   path,
+			Mmh3:   faviconMmh3(resp.Body),
+			Sha256: hex.EncodeToString(sum[:]),
+		}
+	}
+	return nil
+}
+
+// faviconMmh3 hashes the icon like Shodan's http.favicon.hash: the data is
+// base64 encoded with a newline after every 76 characters and at the end
+func faviconMmh3(data []byte) int32 {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var wrapped strings.Builder
+	for len(encoded) > 76 {
+		wrapped.WriteString(encoded[:76])
+		wrapped.WriteByte('\n')
+		encoded = encoded[76:]
+	}
+	wrapped.WriteString(encoded)
+	wrapped.WriteByte('\n')
+	return int32(murmur3(0, []byte(wrapped.String())))
+}
+
+// murmur3 is the 32 bit x86 variant of MurmurHash3
+func murmur3(seed uint32, data []byte) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+	h := seed
+	length := len(data)
+	for len(data) >= 4 {
+		k := binary.LittleEndian.Uint32(data)
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+		data = data[4:]
+	}
+
+	var k uint32
+	switch len(data) {
+	case 3:
+		k ^= uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(data[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+
+	h ^= uint32(length)
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
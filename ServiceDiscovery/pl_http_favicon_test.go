@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestMurmur3(t *testing.T) {
+	tests := []struct {
+		seed uint32
+		data string
+		want uint32
+	}{
+		{0, "", 0},
+		{1, "", 0x514e28b7},
+		{0xffffffff, "", 0x81f16f39},
+		{0, "\x00\x00\x00\x00", 0x2362f9de},
+		{0x9747b28c, "aaaa", 0x5a97808a},
+		{0x9747b28c, "Hello, world!", 0x24884cba},
+		{0x9747b28c, "The quick brown fox jumps over the lazy dog", 0x2fa826cd},
+	}
+	for _, test := range tests {
+		if got := murmur3(test.seed, []byte(test.data)); got != test.want {
+			t.Errorf("murmur3(%#x, %q) = %#x, want %#x", test.seed, test.data, got, test.want)
+		}
+	}
+}
+
+func TestFaviconMmh3(t *testing.T) {
+	icon := make([]byte, 256)
+	for i := range icon {
+		icon[i] = byte(i)
+	}
+	// Reference values of mmh3.hash(codecs.encode(data, "base64")) as used by Shodan,
+	// the 256 byte icon spans several 76 character base64 lines
+	tests := []struct {
+		name string
+		data []byte
+		want int32
+	}{
+		{"single line", []byte("GIF89a"), -851503336},
+		{"wrapped lines", icon, -757223386},
+	}
+	for _, test := range tests {
+		if got := faviconMmh3(test.data); got != test.want {
+			t.Errorf("faviconMmh3(%s) = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// HttpWellKnownPaths are fetched once per HTTP endpoint, application detectors
+// read the responses from the cache instead of sending their own requests.
+// The list can be replaced with the -paths flag.
+var HttpWellKnownPaths = []string{
+	"/version",
+	"/healthz",
+	"/livez",
+	"/readyz",
+	"/metrics",
+	"/api",
+	"/apis",
+	"/openapi/v2",
+	"/debug/pprof/",
+	"/configz",
+	"/stats/summary",
+}
+
+// HttpPathEvidence records what a single path returned, it is kept for every
+// request of the path prober so a detection can be traced back to responses
+type HttpPathEvidence struct {
+	Path        string
+	StatusCode  int
+	StatusLine  string
+	ContentType string
+	Size        int
+	Error       string
+	Time        time.Time
+}
+
+// httpPathCacheEntry is the outcome of the last request of a path. Responses
+// which are not HTTP are kept like responses, network errors and timeouts are
+// only kept as evidence and the next detector requests the path again.
+type httpPathCacheEntry struct {
+	response *HttpResponse
+	err      error
+	evidence HttpPathEvidence
+}
+
+// httpPathCache maps an endpoint to the responses of its paths
+var httpPathCache = make(map[string]map[string]*httpPathCacheEntry)
+
+// httpEndpointKey identifies the endpoint behind a session handler
+func httpEndpointKey(sessionHandler iSessionHandler) string {
+	if _, ok := unwrapSessionHandler(sessionHandler).(*UnixSessionHandler); ok {
+		return UNIX_TARGET_PREFIX + sessionHandler.GetHost()
+	}
+	return net.JoinHostPort(sessionHandler.GetHost(), strconv.Itoa(sessionHandler.GetPort()))
+}
+
+// httpProbePath returns the cached response of a path and fetches it on first use
+func httpProbePath(sessionHandler iSessionHandler, path string) (*HttpResponse, error) {
+	endpoint := httpEndpointKey(sessionHandler)
+	if httpPathCache[endpoint] == nil {
+		httpPathCache[endpoint] = make(map[string]*httpPathCacheEntry)
+	}
+	if entry, ok := httpPathCache[endpoint][path]; ok && httpPathCacheable(entry.err) {
+		return entry.response, entry.err
+	}
+
+	resp, err := httpGet(sessionHandler, path)
+	entry := &httpPathCacheEntry{
+		response: resp,
+		err:      err,
+		evidence: HttpPathEvidence{Path: path, Time: time.Now()},
+	}
+	if err != nil {
+		entry.evidence.Error = err.Error()
+	} else {
+		entry.evidence.StatusCode = resp.StatusCode
+		entry.evidence.StatusLine = resp.StatusLine
+		entry.evidence.ContentType = resp.Header.Get("Content-Type")
+		entry.evidence.Size = len(resp.Body)
+	}
+	httpPathCache[endpoint][path] = entry
+	return resp, err
+}
+
+// httpPathCacheable tells whether the outcome of a request is final, a transient
+// failure must not fail every later detector of the endpoint
+func httpPathCacheable(err error) bool {
+	var parseError *httpParseError
+	return err == nil || errors.As(err, &parseError)
+}
+
+// httpProbePaths fetches every well-known path of the endpoint once and returns
+// the responses by path, paths which failed are left out
+func httpProbePaths(sessionHandler iSessionHandler) map[string]*HttpResponse {
+	responses := make(map[string]*HttpResponse)
+	for _, path := range HttpWellKnownPaths {
+		if resp, err := httpProbePath(sessionHandler, path); err == nil {
+			responses[path] = resp
+		}
+	}
+	return responses
+}
+
+// httpPathEvidence returns the evidence of the given paths of the endpoint, or
+// of every path requested so far when no path is given
+func httpPathEvidence(sessionHandler iSessionHandler, paths ...string) []HttpPathEvidence {
+	entries := httpPathCache[httpEndpointKey(sessionHandler)]
+	var evidence []HttpPathEvidence
+	if len(paths) == 0 {
+		for _, path := range append([]string{"/"}, HttpWellKnownPaths...) {
+			if entry, ok := entries[path]; ok {
+				evidence = append(evidence, entry.evidence)
+			}
+		}
+		// Other paths in a stable order, map iteration is random
+		var others []string
+		for path := range entries {
+			if !httpIsWellKnownPath(path) {
+				others = append(others, path)
+			}
+		}
+		sort.Strings(others)
+		for _, path := range others {
+			evidence = append(evidence, entries[path].evidence)
+		}
+		return evidence
+	}
+	for _, path := range paths {
+		if entry, ok := entries[path]; ok {
+			evidence = append(evidence, entry.evidence)
+		}
+	}
+	return evidence
+}
+
+func httpIsWellKnownPath(path string) bool {
+	if path == "/" {
+		return true
+	}
+	for _, wellKnown := range HttpWellKnownPaths {
+		if path == wellKnown {
+			return true
+		}
+	}
+	return false
+}
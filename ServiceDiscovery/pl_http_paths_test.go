@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHttpProbePathRetriesNetworkErrors(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first request of /version loses its connection without a response
+		if r.URL.Path == "/version" && atomic.AddInt32(&requests, 1) == 1 {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	sessionHandler := sharedHandlerFor(t, server.Listener.Addr().String())
+
+	if _, err := httpProbePath(sessionHandler, "/version"); err == nil {
+		t.Fatal("first request of /version succeeded, want a network error")
+	}
+	resp, err := httpProbePath(sessionHandler, "/version")
+	if err != nil || resp.StatusCode != 200 {
+		t.Fatalf("retry of /version = %v, %v, want 200", resp, err)
+	}
+	if _, err := httpProbePath(sessionHandler, "/version"); err != nil || atomic.LoadInt32(&requests) != 2 {
+		t.Errorf("response was not cached, %d requests of /version", requests)
+	}
+}
+
+func TestHttpPathEvidenceOrder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	sessionHandler := sharedHandlerFor(t, server.Listener.Addr().String())
+
+	for _, path := range []string{"/zz", "/healthz", "/aa", "/mm", "/version"} {
+		if _, err := httpProbePath(sessionHandler, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var paths []string
+	for _, evidence := range httpPathEvidence(sessionHandler) {
+		paths = append(paths, evidence.Path)
+	}
+	want := []string{"/version", "/healthz", "/aa", "/mm", "/zz"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("evidence paths = %v, want %v", paths, want)
+	}
+}
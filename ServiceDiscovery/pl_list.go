@@ -10,4 +10,28 @@ var PresentationDiscoveryList = []PresentationLayerDiscoveryListItem{
 		Discovery:  &HttpDiscovery{},
 		Reqirement: string(TCP),
 	},
+	{
+		Discovery:  &Http2Discovery{},
+		Reqirement: string(TCP),
+	},
+	{
+		Discovery:  &GrpcDiscovery{},
+		Reqirement: string(TCP),
+	},
+	{
+		Discovery:  &Http3Discovery{},
+		Reqirement: string(UDP),
+	},
+	{
+		Discovery:  &HttpDiscovery{},
+		Reqirement: string(UNIX),
+	},
+	{
+		Discovery:  &Http2Discovery{},
+		Reqirement: string(UNIX),
+	},
+	{
+		Discovery:  &GrpcDiscovery{},
+		Reqirement: string(UNIX),
+	},
 }
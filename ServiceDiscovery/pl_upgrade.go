@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// Protocols an HTTP/1.1 connection can be upgraded to for streaming
+const (
+	UPGRADE_WEBSOCKET = "websocket"
+	UPGRADE_SPDY      = "SPDY/3.1"
+)
+
+// websocketAcceptGuid is appended to the key to compute Sec-WebSocket-Accept (RFC 6455)
+const websocketAcceptGuid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Subprotocols of the Kubernetes exec, attach and portforward streams, newest first
+var (
+	kubeWebsocketProtocols = []string{"v5.channel.k8s.io", "v4.channel.k8s.io", "v3.channel.k8s.io", "v2.channel.k8s.io", "channel.k8s.io", "base64.channel.k8s.io"}
+	kubeSpdyProtocols      = []string{"v4.channel.k8s.io", "v3.channel.k8s.io", "v2.channel.k8s.io", "channel.k8s.io"}
+)
+
+// UpgradeCapability is the outcome of one upgrade attempt on a path
+type UpgradeCapability struct {
+	Path     string
+	Protocol string
+	Accepted bool
+	// StatusCode of the response, 101 when the upgrade was accepted
+	StatusCode int
+	// Subprotocol chosen by the server from the offered ones
+	Subprotocol string
+}
+
+// probeUpgrades tries a WebSocket and a SPDY/3.1 upgrade on the path, offering
+// the subprotocols of each. Accepted streams are closed right away without
+// sending any data on them.
+func probeUpgrades(sessionHandler iSessionHandler, path string, websocketProtocols []string, spdyProtocols []string) []UpgradeCapability {
+	return []UpgradeCapability{
+		probeWebsocketUpgrade(sessionHandler, path, websocketProtocols),
+		probeSpdyUpgrade(sessionHandler, path, spdyProtocols),
+	}
+}
+
+func probeWebsocketUpgrade(sessionHandler iSessionHandler, path string, protocols []string) UpgradeCapability {
+	capability := UpgradeCapability{Path: path, Protocol: UPGRADE_WEBSOCKET}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+	headers := http.Header{
+		"Connection":            {"Upgrade"},
+		"Upgrade":               {"websocket"},
+		"Sec-WebSocket-Version": {"13"},
+		"Sec-WebSocket-Key":     {key},
+	}
+	if len(protocols) > 0 {
+		headers["Sec-WebSocket-Protocol"] = []string{strings.Join(protocols, ", ")}
+	}
+
+	resp, _, err := httpUpgrade(sessionHandler, path, headers)
+	if err != nil {
+		return capability
+	}
+	capability.StatusCode = resp.StatusCode
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return capability
+	}
+	defer sessionHandler.Destory()
+
+	// Close frame with status 1000, client frames have to be masked
+	sessionHandler.Write([]byte{0x88, 0x82, 0, 0, 0, 0, 0x03, 0xe8})
+
+	accept := sha1.Sum([]byte(key + websocketAcceptGuid))
+	capability.Accepted = strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") &&
+		resp.Header.Get("Sec-WebSocket-Accept") == base64.StdEncoding.EncodeToString(accept[:])
+	capability.Subprotocol = resp.Header.Get("Sec-WebSocket-Protocol")
+	return capability
+}
+
+func probeSpdyUpgrade(sessionHandler iSessionHandler, path string, protocols []string) UpgradeCapability {
+	capability := UpgradeCapability{Path: path, Protocol: UPGRADE_SPDY}
+
+	headers := http.Header{
+		"Connection": {"Upgrade"},
+		"Upgrade":    {UPGRADE_SPDY},
+	}
+	if len(protocols) > 0 {
+		headers["X-Stream-Protocol-Version"] = protocols
+	}
+
+	resp, _, err := httpUpgrade(sessionHandler, path, headers)
+	if err != nil {
+		return capability
+	}
+	capability.StatusCode = resp.StatusCode
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return capability
+	}
+	// No SPDY frame is sent, closing the connection tears the session down
+	sessionHandler.Destory()
+
+	capability.Accepted = strings.EqualFold(resp.Header.Get("Upgrade"), UPGRADE_SPDY)
+	capability.Subprotocol = resp.Header.Get("X-Stream-Protocol-Version")
+	return capability
+}
+
+// upgradeProperties summarizes upgrade attempts for discovery properties
+func upgradeProperties(capabilities []UpgradeCapability) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for _, capability := range capabilities {
+		key := capability.Path + " " + capability.Protocol
+		if !capability.Accepted {
+			properties[key] = map[string]interface{}{"accepted": false, "statusCode": capability.StatusCode}
+			continue
+		}
+		properties[key] = map[string]interface{}{"accepted": true, "subprotocol": capability.Subprotocol}
+	}
+	return properties
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SessionLayerEvidence records why a session layer protocol was accepted or rejected for a port
+type SessionLayerEvidence struct {
+	Protocol SessionLayerProtocol
+	Detected bool
+	Reason   string
+}
+
+func (e SessionLayerEvidence) String() string {
+	if e.Detected {
+		return fmt.Sprintf("%s: detected (%s)", e.Protocol, e.Reason)
+	}
+	return fmt.Sprintf("%s: rejected (%s)", e.Protocol, e.Reason)
+}
+
+// ClassifySessionLayer decides on exactly one session layer protocol for a port.
+// SessionDiscoveryList is ordered by priority, the first protocol detected wins and
+// the remaining ones are never tried, so a TLS port is never reported as plaintext.
+func ClassifySessionLayer(hostAddr string, port int, transport TransportProtocol) (iSessionLayerDiscoveryResult, []SessionLayerEvidence, error) {
+	var evidence []SessionLayerEvidence
+
+	for _, sessionDiscoveryItem := range SessionDiscoveryList {
+		if sessionDiscoveryItem.Reqirement != string(transport) {
+			continue
+		}
+
+		// Discoveries return an error only when the port can not be reached at all
+		sessionDiscoveryResult, err := sessionDiscoveryItem.Discovery.SessionLayerDiscover(hostAddr, port)
+		if err != nil {
+			return nil, evidence, err
+		}
+
+		if !sessionDiscoveryResult.GetIsDetected() {
+			evidence = append(evidence, SessionLayerEvidence{
+				Protocol: sessionDiscoveryResult.Protocol(),
+				Reason:   sessionLayerReason(sessionDiscoveryResult, "not detected"),
+			})
+			continue
+		}
+
+		evidence = append(evidence, SessionLayerEvidence{
+			Protocol: sessionDiscoveryResult.Protocol(),
+			Detected: true,
+			Reason:   sessionLayerReason(sessionDiscoveryResult, "detected"),
+		})
+		return sessionDiscoveryResult, evidence, nil
+	}
+
+	return nil, evidence, errors.New("no session layer protocol detected")
+}
+
+// sessionLayerReason returns the "evidence" property of a result or the given default
+func sessionLayerReason(sessionDiscoveryResult iSessionLayerDiscoveryResult, defaultReason string) string {
+	if reason, ok := sessionDiscoveryResult.GetProperties()["evidence"].(string); ok && reason != "" {
+		return reason
+	}
+	return defaultReason
+}
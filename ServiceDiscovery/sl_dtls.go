@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pion/dtls/v2"
+)
+
+// DTLS record and handshake types we look for in the answer to a ClientHello
+const (
+	dtlsContentAlert         = 21
+	dtlsContentHandshake     = 22
+	dtlsHandshakeClientHello = 1
+	dtlsHandshakeServerHello = 2
+	dtlsHandshakeHelloVerify = 3
+	dtlsRecordHeaderLength   = 13
+)
+
+// dtlsHandshakeTimeout bounds the full handshake of the session handler
+const dtlsHandshakeTimeout = 5 * time.Second
+
+type DtlsSessionDiscovery struct {
+}
+
+type DtlsSessionDiscoveryResult struct {
+	isDtls   bool
+	host     string
+	port     int
+	version  string
+	evidence string
+}
+
+// DtlsSessionHandler is a datagram-style session handler over a DTLS association
+type DtlsSessionHandler struct {
+	host string
+	port int
+	conn *dtls.Conn
+}
+
+func (d *DtlsSessionDiscovery) Protocol() TransportProtocol {
+	return UDP
+}
+
+// SessionLayerDiscover sends a bare DTLS 1.2 ClientHello. Any DTLS record in the
+// answer (HelloVerifyRequest, ServerHello or an alert) proves the port speaks DTLS,
+// independent of whether we support the cipher suites the server wants.
+func (d *DtlsSessionDiscovery) SessionLayerDiscover(hostAddr string, port int) (iSessionLayerDiscoveryResult, error) {
+	conn, err := sessionDialer.Dial("udp", net.JoinHostPort(hostAddr, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	clientHello, err := dtlsClientHello()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(clientHello); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, udpMaxDatagramSize)
+	conn.SetReadDeadline(time.Now().Add(udpProbeTimeout))
+	n, err := conn.Read(buf)
+	if err != nil {
+		// A refused read is an ICMP port unreachable, the port is closed
+		var netErr net.Error
+		if !(errors.As(err, &netErr) && netErr.Timeout()) {
+			return nil, err
+		}
+		return &DtlsSessionDiscoveryResult{host: hostAddr, port: port, evidence: "no answer to a DTLS ClientHello"}, nil
+	}
+
+	record := buf[:n]
+	if len(record) < dtlsRecordHeaderLength || record[1] != 0xfe {
+		return &DtlsSessionDiscoveryResult{host: hostAddr, port: port, evidence: "answer is not a DTLS record"}, nil
+	}
+
+	result := &DtlsSessionDiscoveryResult{isDtls: true, host: hostAddr, port: port, version: dtlsVersionName(record[1], record[2])}
+	switch {
+	case record[0] == dtlsContentAlert:
+		result.evidence = "server answered the ClientHello with a DTLS alert"
+	case record[0] == dtlsContentHandshake && len(record) > dtlsRecordHeaderLength && record[dtlsRecordHeaderLength] == dtlsHandshakeHelloVerify:
+		result.evidence = "server answered the ClientHello with a HelloVerifyRequest"
+	case record[0] == dtlsContentHandshake && len(record) > dtlsRecordHeaderLength && record[dtlsRecordHeaderLength] == dtlsHandshakeServerHello:
+		result.evidence = "server answered the ClientHello with a ServerHello"
+	default:
+		result.isDtls = false
+		result.evidence = fmt.Sprintf("unexpected DTLS content type %d", record[0])
+	}
+	return result, nil
+}
+
+// dtlsClientHello builds a single record DTLS 1.2 ClientHello without a cookie
+func dtlsClientHello() ([]byte, error) {
+	body := []byte{0xfe, 0xfd}
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	body = append(body, random...)
+	body = append(body, 0x00) // session id
+	body = append(body, 0x00) // cookie
+
+	cipherSuites := []uint16{0xc02b, 0xc02f, 0xc0ae, 0xc00a, 0xc014, 0x009c, 0x00a8, 0x002f, 0x0035}
+	body = binary.BigEndian.AppendUint16(body, uint16(2*len(cipherSuites)))
+	for _, cipherSuite := range cipherSuites {
+		body = binary.BigEndian.AppendUint16(body, cipherSuite)
+	}
+	body = append(body, 0x01, 0x00) // null compression
+
+	extensions := []byte{
+		0x00, 0x0a, 0x00, 0x06, 0x00, 0x04, 0x00, 0x1d, 0x00, 0x17, // supported groups: x25519, secp256r1
+		0x00, 0x0b, 0x00, 0x02, 0x01, 0x00, // ec point formats: uncompressed
+		0x00, 0x0d, 0x00, 0x08, 0x00, 0x06, 0x04, 0x03, 0x08, 0x04, 0x04, 0x01, // signature algorithms
+		0x00, 0x17, 0x00, 0x00, // extended master secret
+		0xff, 0x01, 0x00, 0x01, 0x00, // renegotiation info
+	}
+	body = binary.BigEndian.AppendUint16(body, uint16(len(extensions)))
+	body = append(body, extensions...)
+
+	// Handshake header: type, length, message sequence, fragment offset and length
+	length := []byte{byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}
+	handshake := []byte{dtlsHandshakeClientHello}
+	handshake = append(handshake, length...)
+	handshake = append(handshake, 0x00, 0x00, 0x00, 0x00, 0x00)
+	handshake = append(handshake, length...)
+	handshake = append(handshake, body...)
+
+	// Record header: content type, version, epoch, sequence number and length
+	record := []byte{dtlsContentHandshake, 0xfe, 0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	record = binary.BigEndian.AppendUint16(record, uint16(len(handshake)))
+	return append(record, handshake...), nil
+}
+
+func dtlsVersionName(major, minor byte) string {
+	switch {
+	case major == 0xfe && minor == 0xff:
+		return "DTLS 1.0"
+	case major == 0xfe && minor == 0xfd:
+		return "DTLS 1.2"
+	case major == 0xfe && minor == 0xfc:
+		return "DTLS 1.3"
+	}
+	return fmt.Sprintf("0x%02x%02x", major, minor)
+}
+
+func (d *DtlsSessionDiscoveryResult) Protocol() SessionLayerProtocol {
+	return DTLS
+}
+
+func (d *DtlsSessionDiscoveryResult) GetIsDetected() bool {
+	return d.isDtls
+}
+
+func (d *DtlsSessionDiscoveryResult) GetProperties() map[string]interface{} {
+	return map[string]interface{}{
+		"evidence": d.evidence,
+		"version":  d.version,
+		"proxy":    sessionDialer.String(),
+	}
+}
+
+func (d *DtlsSessionDiscoveryResult) GetSessionHandler() (iSessionHandler, error) {
+	if !d.isDtls {
+		return nil, errors.New("DTLS is not available")
+	}
+	return &DtlsSessionHandler{host: d.host, port: d.port}, nil
+}
+
+func (d *DtlsSessionHandler) Connect() error {
+	conn, err := sessionDialer.Dial("udp", net.JoinHostPort(d.host, strconv.Itoa(d.port)))
+	if err != nil {
+		return err
+	}
+
+	// Create a DTLS config with InsecureSkipVerify set
+	dtlsConfig := &dtls.Config{
+		InsecureSkipVerify:   true,
+		ExtendedMasterSecret: dtls.RequestExtendedMasterSecret,
+		ConnectContextMaker: func() (context.Context, func()) {
+			return context.WithTimeout(context.Background(), dtlsHandshakeTimeout)
+		},
+	}
+
+	dtlsConn, err := dtls.Client(conn, dtlsConfig)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	d.conn = dtlsConn
+	return nil
+}
+
+func (d *DtlsSessionHandler) Destory() error {
+	return d.conn.Close()
+}
+
+func (d *DtlsSessionHandler) Write(data []byte) (int, error) {
+	return d.conn.Write(data)
+}
+
+func (d *DtlsSessionHandler) Read(data []byte) (int, error) {
+	return d.conn.Read(data)
+}
+
+func (d *DtlsSessionHandler) SetReadDeadline(t time.Time) error {
+	return d.conn.SetReadDeadline(t)
+}
+
+func (d *DtlsSessionHandler) GetHost() string {
+	return d.host
+}
+
+func (d *DtlsSessionHandler) GetPort() int {
+	return d.port
+}
+
+func (d *DtlsSessionHandler) GetMaxDatagramSize() int {
+	return udpMaxDatagramSize
+}
@@ -5,13 +5,35 @@ type SessionLayerDiscoveryListItem struct {
 	Reqirement string
 }
 
+// SessionDiscoveryList is ordered by priority, the first detected protocol is
+// the only session layer used for a port (see ClassifySessionLayer)
 var SessionDiscoveryList = []SessionLayerDiscoveryListItem{
 	{
 		Discovery:  &TlsSessionDiscovery{},
 		Reqirement: string(TCP),
 	},
+	{
+		Discovery:  &StartTlsSessionDiscovery{},
+		Reqirement: string(TCP),
+	},
 	{
 		Discovery:  &TcpSessionDiscovery{},
 		Reqirement: string(TCP),
 	},
+	{
+		Discovery:  &QuicSessionDiscovery{},
+		Reqirement: string(UDP),
+	},
+	{
+		Discovery:  &DtlsSessionDiscovery{},
+		Reqirement: string(UDP),
+	},
+	{
+		Discovery:  &UdpSessionDiscovery{},
+		Reqirement: string(UDP),
+	},
+	{
+		Discovery:  &UnixSessionDiscovery{},
+		Reqirement: string(UNIX),
+	},
 }
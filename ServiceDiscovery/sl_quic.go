@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// quicProbeVersion is a reserved version (RFC 9000 section 15) which forces a Version Negotiation
+const quicProbeVersion = 0x1a2a3a4a
+
+// quicMinInitialSize is the minimum datagram size of a client Initial packet
+const quicMinInitialSize = 1200
+
+// quicHandshakeTimeout bounds the handshake used to learn the ALPN
+const quicHandshakeTimeout = 5 * time.Second
+
+// quicAlpnOffers are the application protocols we offer in the handshake, HTTP/3 first
+var quicAlpnOffers = []string{"h3", "h3-29", "hq-interop", "doq"}
+
+var quicVersionNames = map[uint32]string{
+	0x00000001: "v1",
+	0x6b3343cf: "v2",
+	0xff00001d: "draft-29",
+	0xff000020: "draft-32",
+	0xff000022: "draft-34",
+}
+
+type QuicSessionDiscovery struct {
+}
+
+type QuicSessionDiscoveryResult struct {
+	isQuic   bool
+	host     string
+	port     int
+	versions []string
+	alpn     string
+	tlsState tls.ConnectionState
+	evidence string
+}
+
+// QuicSessionHandler reads and writes a single bidirectional QUIC stream
+type QuicSessionHandler struct {
+	host     string
+	port     int
+	alpn     string
+	versions []string
+	conn     *quic.Conn
+	stream   *quic.Stream
+}
+
+func (d *QuicSessionDiscovery) Protocol() TransportProtocol {
+	return UDP
+}
+
+// SessionLayerDiscover sends an Initial packet with a reserved version to learn the
+// supported versions from the Version Negotiation, then completes a handshake to
+// learn the ALPN. Either answer proves the port speaks QUIC.
+func (d *QuicSessionDiscovery) SessionLayerDiscover(hostAddr string, port int) (iSessionLayerDiscoveryResult, error) {
+	address := net.JoinHostPort(hostAddr, strconv.Itoa(port))
+	result := &QuicSessionDiscoveryResult{host: hostAddr, port: port}
+
+	versions, err := quicVersionNegotiation(address)
+	if err != nil {
+		return nil, err
+	}
+	result.versions = versions
+
+	conn, err := quicDial(address)
+	if err == nil {
+		state := conn.ConnectionState()
+		result.isQuic = true
+		result.alpn = state.TLS.NegotiatedProtocol
+		result.tlsState = state.TLS
+		result.evidence = "QUIC handshake completed with ALPN " + result.alpn
+		conn.CloseWithError(0, "")
+		return result, nil
+	}
+
+	// A transport error from the server (e.g. no_application_protocol) is still QUIC
+	var transportErr *quic.TransportError
+	switch {
+	case errors.As(err, &transportErr) && transportErr.Remote:
+		result.isQuic = true
+		result.evidence = "server closed the QUIC handshake: " + transportErr.Error()
+	case len(versions) > 0:
+		result.isQuic = true
+		result.evidence = "server sent a Version Negotiation packet"
+	default:
+		result.evidence = "no answer to a QUIC Initial packet"
+	}
+	return result, nil
+}
+
+// quicVersionNegotiation sends an Initial packet with an unsupported version and
+// returns the versions listed in the Version Negotiation packet, if any
+func quicVersionNegotiation(address string) ([]string, error) {
+	conn, err := sessionDialer.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	dcid := make([]byte, 8)
+	scid := make([]byte, 8)
+	if _, err := rand.Read(dcid); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(scid); err != nil {
+		return nil, err
+	}
+
+	// Long header Initial packet: flags, version, connection IDs, empty token,
+	// length and a packet number, padded to the minimum Initial size
+	packet := []byte{0xc0}
+	packet = binary.BigEndian.AppendUint32(packet, quicProbeVersion)
+	packet = append(packet, byte(len(dcid)))
+	packet = append(packet, dcid...)
+	packet = append(packet, byte(len(scid)))
+	packet = append(packet, scid...)
+	packet = append(packet, 0x00)
+	remaining := quicMinInitialSize - len(packet) - 2
+	packet = append(packet, 0x40|byte(remaining>>8), byte(remaining))
+	packet = append(packet, make([]byte, remaining)...)
+
+	if _, err := conn.Write(packet); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, udpMaxDatagramSize)
+	conn.SetReadDeadline(time.Now().Add(udpProbeTimeout))
+	n, err := conn.Read(buf)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, nil
+		}
+		return nil, err
+	}
+	response := buf[:n]
+
+	// Version Negotiation: long header bit, version 0, echoed connection IDs, versions
+	if len(response) < 7 || response[0]&0x80 == 0 || binary.BigEndian.Uint32(response[1:5]) != 0 {
+		return nil, nil
+	}
+	offset := 5
+	for i := 0; i < 2; i++ {
+		if offset >= len(response) {
+			return nil, nil
+		}
+		offset += 1 + int(response[offset])
+	}
+
+	var versions []string
+	for ; offset+4 <= len(response); offset += 4 {
+		version := binary.BigEndian.Uint32(response[offset : offset+4])
+		// Versions following the 0x?a?a?a?a pattern are greased, not supported
+		if version&0x0f0f0f0f == 0x0a0a0a0a {
+			continue
+		}
+		versions = append(versions, quicVersionName(version))
+	}
+	return versions, nil
+}
+
+func quicVersionName(version uint32) string {
+	if name, ok := quicVersionNames[version]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%08x", version)
+}
+
+func quicDial(address string) (*quic.Conn, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         quicAlpnOffers,
+	}
+	quicConfig := &quic.Config{
+		HandshakeIdleTimeout: quicHandshakeTimeout,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), quicHandshakeTimeout)
+	defer cancel()
+	return quic.DialAddr(ctx, address, tlsConfig, quicConfig)
+}
+
+func (d *QuicSessionDiscoveryResult) Protocol() SessionLayerProtocol {
+	return QUIC
+}
+
+func (d *QuicSessionDiscoveryResult) GetIsDetected() bool {
+	return d.isQuic
+}
+
+func (d *QuicSessionDiscoveryResult) GetProperties() map[string]interface{} {
+	properties := map[string]interface{}{
+		"evidence": d.evidence,
+		"versions": strings.Join(d.versions, ","),
+		"alpn":     d.alpn,
+	}
+	if len(d.tlsState.PeerCertificates) > 0 {
+		cert := d.tlsState.PeerCertificates[0]
+		properties["subject"] = cert.Subject.String()
+		properties["issuer"] = cert.Issuer.String()
+		properties["dnsNames"] = cert.DNSNames
+		properties["notAfter"] = cert.NotAfter
+	}
+	return properties
+}
+
+func (d *QuicSessionDiscoveryResult) GetSessionHandler() (iSessionHandler, error) {
+	if !d.isQuic {
+		return nil, errors.New("QUIC is not available")
+	}
+	return &QuicSessionHandler{host: d.host, port: d.port, alpn: d.alpn, versions: d.versions}, nil
+}
+
+func (d *QuicSessionHandler) Connect() error {
+	conn, err := quicDial(net.JoinHostPort(d.host, strconv.Itoa(d.port)))
+	if err != nil {
+		return err
+	}
+	stream, err := conn.OpenStream()
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return err
+	}
+	d.conn = conn
+	d.stream = stream
+	return nil
+}
+
+func (d *QuicSessionHandler) Destory() error {
+	return d.conn.CloseWithError(0, "")
+}
+
+func (d *QuicSessionHandler) Write(data []byte) (int, error) {
+	return d.stream.Write(data)
+}
+
+func (d *QuicSessionHandler) Read(data []byte) (int, error) {
+	return d.stream.Read(data)
+}
+
+func (d *QuicSessionHandler) SetReadDeadline(t time.Time) error {
+	return d.stream.SetReadDeadline(t)
+}
+
+func (d *QuicSessionHandler) GetHost() string {
+	return d.host
+}
+
+func (d *QuicSessionHandler) GetPort() int {
+	return d.port
+}
+
+// GetAlpn returns the application protocol negotiated during discovery
+func (d *QuicSessionHandler) GetAlpn() string {
+	return d.alpn
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// sharedBannerTimeout is how long a fresh connection waits for a server-first banner
+const sharedBannerTimeout = 2 * time.Second
+
+// SharedSessionHandler is handed to every detector of a port instead of the raw
+// session handler. It keeps connections which have only been read from in a pool
+// so they can be reused, and it caches the first read of each connection so the
+// server banner is replayed to every detector calling Connect().
+type SharedSessionHandler struct {
+	sessionDiscoveryResult iSessionLayerDiscoveryResult
+	host                   string
+	port                   int
+	// banner is the first read of the first connection, empty for client-first protocols
+	banner     []byte
+	bannerRead bool
+	active     *pooledSession
+	idle       []*pooledSession
+	first      iSessionHandler
+	spare      iSessionHandler
+}
+
+// pooledSession is a single underlying connection together with its first-read cache
+type pooledSession struct {
+	handler iSessionHandler
+	replay  []byte
+	pos     int
+	// dirty is set once data was written or read past the banner, such
+	// connections can not be shown to another detector and are closed on release
+	dirty bool
+}
+
+func NewSharedSessionHandler(sessionDiscoveryResult iSessionLayerDiscoveryResult) (*SharedSessionHandler, error) {
+	handler, err := sessionDiscoveryResult.GetSessionHandler()
+	if err != nil {
+		return nil, err
+	}
+	return &SharedSessionHandler{
+		sessionDiscoveryResult: sessionDiscoveryResult,
+		host:                   handler.GetHost(),
+		port:                   handler.GetPort(),
+		first:                  handler,
+		spare:                  handler,
+	}, nil
+}
+
+// Connect takes a clean connection from the pool or opens a new one. Calling it
+// while a connection is active rewinds the banner if nothing was written yet.
+func (d *SharedSessionHandler) Connect() error {
+	if d.active != nil {
+		if !d.active.dirty {
+			d.active.pos = 0
+			return nil
+		}
+		d.release(d.active)
+	}
+
+	if len(d.idle) > 0 {
+		d.active = d.idle[len(d.idle)-1]
+		d.idle = d.idle[:len(d.idle)-1]
+		d.active.pos = 0
+		return nil
+	}
+
+	session, err := d.open()
+	if err != nil {
+		return err
+	}
+	d.active = session
+	return nil
+}
+
+// open creates a new underlying connection and fills its first-read cache
+func (d *SharedSessionHandler) open() (*pooledSession, error) {
+	handler := d.spare
+	d.spare = nil
+	if handler == nil {
+		var err error
+		handler, err = d.sessionDiscoveryResult.GetSessionHandler()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := handler.Connect(); err != nil {
+		return nil, err
+	}
+
+	session := &pooledSession{handler: handler}
+
+	// Datagram protocols never speak first
+	if _, ok := handler.(iDatagramSessionHandler); ok {
+		d.bannerRead = true
+		return session, nil
+	}
+
+	// Only the first connection has to wait for the banner, later ones wait only
+	// if the server is known to speak first
+	if !d.bannerRead || len(d.banner) > 0 {
+		buf := make([]byte, 4096)
+		handler.SetReadDeadline(time.Now().Add(sharedBannerTimeout))
+		n, err := handler.Read(buf)
+		handler.SetReadDeadline(time.Time{})
+		var netErr net.Error
+		if err != nil && !(errors.As(err, &netErr) && netErr.Timeout()) {
+			handler.Destory()
+			return nil, err
+		}
+		session.replay = buf[:n]
+		if !d.bannerRead {
+			d.banner = session.replay
+			d.bannerRead = true
+		}
+	}
+	return session, nil
+}
+
+// release puts a clean connection back into the pool and closes a dirty one
+func (d *SharedSessionHandler) release(session *pooledSession) {
+	if d.active == session {
+		d.active = nil
+	}
+	if session.dirty {
+		session.handler.Destory()
+		return
+	}
+	d.idle = append(d.idle, session)
+}
+
+// Destory releases the active connection, the pool is kept open until Close
+func (d *SharedSessionHandler) Destory() error {
+	if d.active != nil {
+		d.release(d.active)
+	}
+	return nil
+}
+
+// Close closes every connection of the pool
+func (d *SharedSessionHandler) Close() error {
+	var firstErr error
+	if d.active != nil {
+		d.active.dirty = true
+		d.release(d.active)
+	}
+	for _, session := range d.idle {
+		if err := session.handler.Destory(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	d.idle = nil
+	return firstErr
+}
+
+func (d *SharedSessionHandler) Write(data []byte) (int, error) {
+	if d.active == nil {
+		return 0, errors.New("session is not connected")
+	}
+	d.active.dirty = true
+	return d.active.handler.Write(data)
+}
+
+func (d *SharedSessionHandler) Read(data []byte) (int, error) {
+	if d.active == nil {
+		return 0, errors.New("session is not connected")
+	}
+	if d.active.pos < len(d.active.replay) {
+		n := copy(data, d.active.replay[d.active.pos:])
+		d.active.pos += n
+		return n, nil
+	}
+	d.active.dirty = true
+	return d.active.handler.Read(data)
+}
+
+// Peek returns the banner sent by the server before the client wrote anything
+func (d *SharedSessionHandler) Peek() ([]byte, error) {
+	if !d.bannerRead {
+		if err := d.Connect(); err != nil {
+			return nil, err
+		}
+	}
+	return d.banner, nil
+}
+
+func (d *SharedSessionHandler) SetReadDeadline(t time.Time) error {
+	if d.active == nil {
+		return errors.New("session is not connected")
+	}
+	return d.active.handler.SetReadDeadline(t)
+}
+
+func (d *SharedSessionHandler) GetHost() string {
+	return d.host
+}
+
+func (d *SharedSessionHandler) GetPort() int {
+	return d.port
+}
+
+// GetSessionHandler returns the underlying handler of the active connection
+func (d *SharedSessionHandler) GetSessionHandler() iSessionHandler {
+	if d.active != nil {
+		return d.active.handler
+	}
+	return d.first
+}
+
+// unwrapSessionHandler returns the protocol specific handler behind a shared handler
+func unwrapSessionHandler(sessionHandler iSessionHandler) iSessionHandler {
+	if shared, ok := sessionHandler.(*SharedSessionHandler); ok {
+		return shared.GetSessionHandler()
+	}
+	return sessionHandler
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+)
+
+// TLS modes reported for protocols which negotiate TLS inside the protocol
+const (
+	TLS_MODE_OFFERED     = "offered"
+	TLS_MODE_REQUIRED    = "required"
+	TLS_MODE_UNAVAILABLE = "unavailable"
+)
+
+// MySQL capability flags used by the SSL upgrade
+const (
+	mysqlClientProtocol41       = 0x00000200
+	mysqlClientSSL              = 0x00000800
+	mysqlClientSecureConnection = 0x00008000
+)
+
+// mysqlErSecureTransportRequired is the error of a plaintext login while
+// require_secure_transport is on
+const mysqlErSecureTransportRequired = 3159
+
+// postgresSSLRequestCode is the magic protocol version of the SSLRequest message
+const postgresSSLRequestCode = 80877103
+
+// starttlsBannerTimeout is how long we wait for a server-first greeting
+const starttlsBannerTimeout = 2 * time.Second
+
+// starttlsUpgrader implements the in-protocol TLS negotiation of a single protocol.
+// Upgrade is called on a fresh connection, banner holds the server greeting if the
+// server spoke first. It returns the TLS mode of the server and whether the
+// connection is ready for the TLS handshake.
+type starttlsUpgrader struct {
+	name        string
+	serverFirst bool
+	upgrade     func(conn net.Conn, address string, banner []byte) (string, bool, error)
+}
+
+var starttlsUpgraders = []starttlsUpgrader{
+	{
+		name:        "mysql",
+		serverFirst: true,
+		upgrade:     mysqlStartTls,
+	},
+	{
+		name:        "postgresql",
+		serverFirst: false,
+		upgrade:     postgresStartTls,
+	},
+}
+
+type StartTlsSessionDiscovery struct {
+}
+
+type StartTlsSessionDiscoveryResult struct {
+	isDetected bool
+	host       string
+	port       int
+	upgrader   string
+	tlsMode    string
+	tlsState   tls.ConnectionState
+}
+
+type StartTlsSessionHandler struct {
+	host     string
+	port     int
+	upgrader string
+	tlsMode  string
+	conn     *tls.Conn
+	// banner is the plaintext server greeting read before the upgrade,
+	// it is replayed to the first reads so detectors can still decode it
+	banner []byte
+}
+
+func (d *StartTlsSessionDiscovery) Protocol() TransportProtocol {
+	return TCP
+}
+
+func (d *StartTlsSessionDiscovery) SessionLayerDiscover(hostAddr string, port int) (iSessionLayerDiscoveryResult, error) {
+	// Try upgraders until one of them recognizes the protocol
+	for _, upgrader := range starttlsUpgraders {
+		conn, _, tlsMode, ok, err := starttlsNegotiate(hostAddr, port, upgrader)
+		if err != nil {
+			continue
+		}
+		if !ok {
+			conn.Close()
+			if tlsMode == TLS_MODE_UNAVAILABLE {
+				// The protocol was recognized but does not offer TLS
+				return &StartTlsSessionDiscoveryResult{host: hostAddr, port: port, upgrader: upgrader.name, tlsMode: tlsMode}, nil
+			}
+			continue
+		}
+
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			continue
+		}
+		state := tlsConn.ConnectionState()
+		tlsConn.Close()
+
+		return &StartTlsSessionDiscoveryResult{
+			isDetected: true,
+			host:       hostAddr,
+			port:       port,
+			upgrader:   upgrader.name,
+			tlsMode:    tlsMode,
+			tlsState:   state,
+		}, nil
+	}
+
+	return &StartTlsSessionDiscoveryResult{host: hostAddr, port: port}, nil
+}
+
+// starttlsNegotiate opens a connection and runs the plaintext part of the upgrade
+func starttlsNegotiate(hostAddr string, port int, upgrader starttlsUpgrader) (net.Conn, []byte, string, bool, error) {
+	address := net.JoinHostPort(hostAddr, strconv.Itoa(port))
+	conn, err := sessionDialer.Dial("tcp", address)
+	if err != nil {
+		return nil, nil, "", false, err
+	}
+
+	var banner []byte
+	if upgrader.serverFirst {
+		banner = make([]byte, 1024)
+		conn.SetReadDeadline(time.Now().Add(starttlsBannerTimeout))
+		n, err := conn.Read(banner)
+		conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			conn.Close()
+			return nil, nil, "", false, err
+		}
+		banner = banner[:n]
+	}
+
+	tlsMode, ok, err := upgrader.upgrade(conn, address, banner)
+	if err != nil {
+		conn.Close()
+		return nil, nil, "", false, err
+	}
+	return conn, banner, tlsMode, ok, nil
+}
+
+func (d *StartTlsSessionDiscoveryResult) Protocol() SessionLayerProtocol {
+	return STARTTLS
+}
+
+func (d *StartTlsSessionDiscoveryResult) GetIsDetected() bool {
+	return d.isDetected
+}
+
+func (d *StartTlsSessionDiscoveryResult) GetProperties() map[string]interface{} {
+	properties := map[string]interface{}{
+		"upgrader": d.upgrader,
+		"tls":      d.tlsMode,
+		"proxy":    sessionDialer.String(),
+	}
+	switch {
+	case d.isDetected:
+		properties["evidence"] = d.upgrader + " accepted the in-protocol TLS upgrade"
+	case d.upgrader != "":
+		properties["evidence"] = d.upgrader + " does not offer an in-protocol TLS upgrade"
+	default:
+		properties["evidence"] = "no in-protocol TLS upgrade recognized"
+	}
+	if d.isDetected {
+		properties["tlsVersion"] = tls.VersionName(d.tlsState.Version)
+		properties["cipherSuite"] = tls.CipherSuiteName(d.tlsState.CipherSuite)
+		if len(d.tlsState.PeerCertificates) > 0 {
+			cert := d.tlsState.PeerCertificates[0]
+			properties["subject"] = cert.Subject.String()
+			properties["issuer"] = cert.Issuer.String()
+			properties["dnsNames"] = cert.DNSNames
+			properties["notAfter"] = cert.NotAfter
+		}
+	}
+	return properties
+}
+
+func (d *StartTlsSessionDiscoveryResult) GetSessionHandler() (iSessionHandler, error) {
+	if !d.isDetected {
+		return nil, errors.New("in-protocol TLS upgrade is not available")
+	}
+	return &StartTlsSessionHandler{host: d.host, port: d.port, upgrader: d.upgrader, tlsMode: d.tlsMode}, nil
+}
+
+func (d *StartTlsSessionHandler) Connect() error {
+	for _, upgrader := range starttlsUpgraders {
+		if upgrader.name != d.upgrader {
+			continue
+		}
+		conn, banner, _, ok, err := starttlsNegotiate(d.host, d.port, upgrader)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			conn.Close()
+			return errors.New("server refused the TLS upgrade")
+		}
+
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return err
+		}
+		d.conn = tlsConn
+		d.banner = banner
+		return nil
+	}
+	return errors.New("unknown upgrader " + d.upgrader)
+}
+
+func (d *StartTlsSessionHandler) Destory() error {
+	if d.conn == nil {
+		return nil
+	}
+	return d.conn.Close()
+}
+
+func (d *StartTlsSessionHandler) Write(data []byte) (int, error) {
+	return d.conn.Write(data)
+}
+
+func (d *StartTlsSessionHandler) Read(data []byte) (int, error) {
+	if len(d.banner) > 0 {
+		n := copy(data, d.banner)
+		d.banner = d.banner[n:]
+		return n, nil
+	}
+	return d.conn.Read(data)
+}
+
+func (d *StartTlsSessionHandler) SetReadDeadline(t time.Time) error {
+	return d.conn.SetReadDeadline(t)
+}
+
+func (d *StartTlsSessionHandler) GetHost() string {
+	return d.host
+}
+
+func (d *StartTlsSessionHandler) GetPort() int {
+	return d.port
+}
+
+// GetTlsMode returns whether the server offers or requires the TLS upgrade
+func (d *StartTlsSessionHandler) GetTlsMode() string {
+	return d.tlsMode
+}
+
+// sessionTlsMode reports the TLS mode of the channel a detector is running over
+func sessionTlsMode(sessionHandler iSessionHandler) string {
+	switch handler := unwrapSessionHandler(sessionHandler).(type) {
+	case *StartTlsSessionHandler:
+		return handler.GetTlsMode()
+	case *TlsSessionHandler:
+		return TLS_MODE_REQUIRED
+	}
+	return TLS_MODE_UNAVAILABLE
+}
+
+// mysqlStartTls checks the SSL capability flag in the greeting and sends an SSLRequest packet
+func mysqlStartTls(conn net.Conn, address string, banner []byte) (string, bool, error) {
+	packet := &InitialHandshakePacket{}
+	if err := packet.DecodeBytes(banner); err != nil {
+		return "", false, err
+	}
+	if packet.CapabilityFlags&mysqlClientSSL == 0 {
+		return TLS_MODE_UNAVAILABLE, false, nil
+	}
+
+	// SSLRequest: capability flags, max packet size, character set and 23 bytes of filler
+	payload := make([]byte, 32)
+	binary.LittleEndian.PutUint32(payload[0:4], mysqlClientSSL|mysqlClientProtocol41|mysqlClientSecureConnection)
+	binary.LittleEndian.PutUint32(payload[4:8], 1<<24)
+	payload[8] = 0x21 // utf8_general_ci
+
+	header := []byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), packet.header.SequenceId + 1}
+	if _, err := conn.Write(append(header, payload...)); err != nil {
+		return "", false, err
+	}
+	if mysqlRequiresTls(address) {
+		return TLS_MODE_REQUIRED, true, nil
+	}
+	return TLS_MODE_OFFERED, true, nil
+}
+
+// mysqlRequiresTls sends a plaintext HandshakeResponse and checks whether the server
+// rejects it because the connection is not encrypted
+func mysqlRequiresTls(address string) bool {
+	conn, err := sessionDialer.Dial("tcp", address)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(starttlsBannerTimeout))
+	greeting := make([]byte, 1024)
+	n, err := conn.Read(greeting)
+	if err != nil {
+		return false
+	}
+	packet := &InitialHandshakePacket{}
+	if err := packet.DecodeBytes(greeting[:n]); err != nil {
+		return false
+	}
+
+	// HandshakeResponse41 without the SSL flag: capability flags, max packet size,
+	// character set, 23 bytes of filler, user name and an empty auth response
+	payload := make([]byte, 32, 64)
+	binary.LittleEndian.PutUint32(payload[0:4], mysqlClientProtocol41|mysqlClientSecureConnection)
+	binary.LittleEndian.PutUint32(payload[4:8], 1<<24)
+	payload[8] = 0x21 // utf8_general_ci
+	payload = append(payload, "root\x00\x00"...)
+
+	header := []byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), packet.header.SequenceId + 1}
+	if _, err := conn.Write(append(header, payload...)); err != nil {
+		return false
+	}
+
+	response := make([]byte, 1024)
+	n, err = conn.Read(response)
+	// An ERR packet starts with 0xff after the 4 byte header, followed by the error code
+	if err != nil || n < 7 || response[4] != 0xff {
+		return false
+	}
+	return binary.LittleEndian.Uint16(response[5:7]) == mysqlErSecureTransportRequired
+}
+
+// postgresStartTls sends an SSLRequest, the server answers with a single 'S' or 'N'
+func postgresStartTls(conn net.Conn, address string, banner []byte) (string, bool, error) {
+	request := make([]byte, 8)
+	binary.BigEndian.PutUint32(request[0:4], 8)
+	binary.BigEndian.PutUint32(request[4:8], postgresSSLRequestCode)
+	if _, err := conn.Write(request); err != nil {
+		return "", false, err
+	}
+
+	response := make([]byte, 1)
+	conn.SetReadDeadline(time.Now().Add(starttlsBannerTimeout))
+	_, err := conn.Read(response)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return "", false, err
+	}
+
+	switch response[0] {
+	case 'S':
+		if postgresRequiresTls(address) {
+			return TLS_MODE_REQUIRED, true, nil
+		}
+		return TLS_MODE_OFFERED, true, nil
+	case 'N':
+		return TLS_MODE_UNAVAILABLE, false, nil
+	}
+	return "", false, errors.New("not a PostgreSQL server")
+}
+
+// postgresRequiresTls sends a plaintext StartupMessage and checks whether the server
+// rejects it because the connection is not encrypted
+func postgresRequiresTls(address string) bool {
+	conn, err := sessionDialer.Dial("tcp", address)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	params := []byte("user\x00postgres\x00database\x00postgres\x00\x00")
+	startup := make([]byte, 8, 8+len(params))
+	binary.BigEndian.PutUint32(startup[0:4], uint32(8+len(params)))
+	binary.BigEndian.PutUint32(startup[4:8], 196608) // protocol 3.0
+	if _, err := conn.Write(append(startup, params...)); err != nil {
+		return false
+	}
+
+	response := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(starttlsBannerTimeout))
+	n, err := conn.Read(response)
+	if err != nil || n == 0 || response[0] != 'E' {
+		return false
+	}
+	// pg_hba.conf rejections of plaintext connections mention "no encryption" or "SSL off"
+	return bytes.Contains(response[:n], []byte("no encryption")) || bytes.Contains(response[:n], []byte("SSL off"))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// mysqlTestServer answers every plaintext HandshakeResponse with the given packet
+func mysqlTestServer(t *testing.T, response []byte) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	payload := append([]byte{0x0a}, "8.0.34\x00"...)
+	payload = append(payload, 1, 0, 0, 0)                       // connection id
+	payload = append(payload, "12345678\x00"...)                // auth-plugin-data-part-1 and filler
+	payload = binary.LittleEndian.AppendUint16(payload, 0xffff) // lower capability flags
+	payload = append(payload, 0x21, 0x02, 0x00)                 // character set and status flags
+	payload = binary.LittleEndian.AppendUint16(payload, 0x0000) // upper capability flags
+	greeting := append([]byte{byte(len(payload)), 0, 0, 0}, payload...)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write(greeting)
+			conn.Read(make([]byte, 1024))
+			conn.Write(response)
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestMysqlRequiresTls(t *testing.T) {
+	message := "#HY000Connections using insecure transport are prohibited while --require_secure_transport=ON."
+	secureTransportRequired := append([]byte{byte(3 + len(message)), 0, 0, 2, 0xff, 0x57, 0x0c}, message...)
+	ok := []byte{7, 0, 0, 2, 0x00, 0, 0, 0x02, 0, 0, 0}
+	accessDenied := append([]byte{byte(3 + len("#28000Access denied")), 0, 0, 2, 0xff, 0x15, 0x04}, "#28000Access denied"...)
+
+	tests := []struct {
+		name     string
+		response []byte
+		want     bool
+	}{
+		{name: "require_secure_transport", response: secureTransportRequired, want: true},
+		{name: "plaintext login accepted", response: ok, want: false},
+		{name: "access denied", response: accessDenied, want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := mysqlRequiresTls(mysqlTestServer(t, test.response)); got != test.want {
+				t.Errorf("mysqlRequiresTls = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
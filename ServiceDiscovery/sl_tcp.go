@@ -1,10 +1,16 @@
 package main
 
 import (
-	"fmt"
 	"net"
+	"strconv"
+	"time"
+
+	"kubescanner/dialer"
 )
 
+// sessionDialer is used by the session handlers, it is replaced by main when a proxy is configured
+var sessionDialer dialer.Dialer = &dialer.DirectDialer{Timeout: 5 * time.Second}
+
 type TcpSessionDiscovery struct {
 }
 
@@ -24,7 +30,7 @@ func (d *TcpSessionDiscovery) Protocol() TransportProtocol {
 }
 
 func (d *TcpSessionDiscovery) SessionLayerDiscover(hostAddr string, port int) (iSessionLayerDiscoveryResult, error) {
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", hostAddr, port))
+	conn, err := sessionDialer.Dial("tcp", net.JoinHostPort(hostAddr, strconv.Itoa(port)))
 	if err != nil {
 		return nil, err
 	}
@@ -42,7 +48,10 @@ func (d *TcpSessionDiscoveryResult) GetIsDetected() bool {
 }
 
 func (d *TcpSessionDiscoveryResult) GetProperties() map[string]interface{} {
-	return nil
+	return map[string]interface{}{
+		"evidence": "TCP connection accepted without a session layer handshake",
+		"proxy":    sessionDialer.String(),
+	}
 }
 
 func (d *TcpSessionDiscoveryResult) GetSessionHandler() (iSessionHandler, error) {
@@ -50,7 +59,7 @@ func (d *TcpSessionDiscoveryResult) GetSessionHandler() (iSessionHandler, error)
 }
 
 func (d *TcpSessionHandler) Connect() error {
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", d.host, d.port))
+	conn, err := sessionDialer.Dial("tcp", net.JoinHostPort(d.host, strconv.Itoa(d.port)))
 	if err != nil {
 		return err
 	}
@@ -70,6 +79,10 @@ func (d *TcpSessionHandler) Read(data []byte) (int, error) {
 	return d.conn.Read(data)
 }
 
+func (d *TcpSessionHandler) SetReadDeadline(t time.Time) error {
+	return d.conn.SetReadDeadline(t)
+}
+
 func (d *TcpSessionHandler) GetHost() string {
 	return d.host
 }
@@ -2,22 +2,108 @@ package main
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type TlsSessionDiscovery struct {
 }
 
+// tlsClientCertificates are presented to servers asking for a client
+// certificate, they are loaded with LoadTlsClientCertificate
+var tlsClientCertificates []tls.Certificate
+
+// LoadTlsClientCertificate loads a PEM certificate and key pair presented on
+// every TLS connection, e.g. an etcd or kubelet client certificate
+func LoadTlsClientCertificate(certFile string, keyFile string) error {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	tlsClientCertificates = []tls.Certificate{certificate}
+	return nil
+}
+
+// errTlsClientCertificateRequired is returned by TLS session handlers when the
+// server rejects the connection for a missing or rejected client certificate
+var errTlsClientCertificateRequired = errors.New("TLS client certificate required")
+
+// Client certificate outcomes of a TLS session
+const (
+	TLS_CLIENT_CERT_NOT_REQUESTED = "not requested"
+	TLS_CLIENT_CERT_REQUESTED     = "requested"
+	TLS_CLIENT_CERT_REQUIRED      = "required"
+	TLS_CLIENT_CERT_ACCEPTED      = "accepted"
+	TLS_CLIENT_CERT_REJECTED      = "rejected"
+)
+
+// tlsClientCertificateRequest records whether the server asked for a client
+// certificate during a handshake and whether one was sent
+type tlsClientCertificateRequest struct {
+	requested bool
+	sent      bool
+}
+
+// getClientCertificate is the tls.Config.GetClientCertificate callback, it
+// presents the first loaded certificate the server accepts or else none
+func (r *tlsClientCertificateRequest) getClientCertificate(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.requested = true
+	for i := range tlsClientCertificates {
+		if info.SupportsCertificate(&tlsClientCertificates[i]) == nil {
+			r.sent = true
+			return &tlsClientCertificates[i], nil
+		}
+	}
+	// A certificate of a CA the server does not accept would only be rejected,
+	// servers asking for one optionally (kubelet, apiserver) proceed without
+	return &tls.Certificate{}, nil
+}
+
+// outcome tells what became of the client certificate given the alert the
+// server sent, if any
+func (r *tlsClientCertificateRequest) outcome(alert bool) string {
+	switch {
+	case !r.requested:
+		return TLS_CLIENT_CERT_NOT_REQUESTED
+	case r.sent && alert:
+		return TLS_CLIENT_CERT_REJECTED
+	case r.sent:
+		return TLS_CLIENT_CERT_ACCEPTED
+	case alert:
+		return TLS_CLIENT_CERT_REQUIRED
+	}
+	return TLS_CLIENT_CERT_REQUESTED
+}
+
+// isTlsRemoteAlert tells whether err is a fatal alert sent by the server
+func isTlsRemoteAlert(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "remote error: tls: ")
+}
+
 type TlsSessionDiscoveryResult struct {
-	isTls bool
-	host  string
-	port  int
+	isTls    bool
+	host     string
+	port     int
+	evidence string
+	tlsState tls.ConnectionState
+	// peerCertificate is kept even when the handshake ends with an alert
+	peerCertificate   *x509.Certificate
+	clientCertificate string
 }
 
 type TlsSessionHandler struct {
 	host string
 	port int
 	conn *tls.Conn
+	// nextProtos are offered as ALPN protocols, none are offered when empty
+	nextProtos []string
+	// clientCertificate describes the last Connect
+	clientCertificate tlsClientCertificateRequest
 }
 
 func (d *TlsSessionDiscovery) Protocol() TransportProtocol {
@@ -25,20 +111,70 @@ func (d *TlsSessionDiscovery) Protocol() TransportProtocol {
 }
 
 func (d *TlsSessionDiscovery) SessionLayerDiscover(hostAddr string, port int) (iSessionLayerDiscoveryResult, error) {
-	// Create a TLS config with InsecureSkipVerify set
+	conn, err := sessionDialer.Dial("tcp", net.JoinHostPort(hostAddr, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Create a TLS config with InsecureSkipVerify set, the serving certificate
+	// is recorded before the server can reject a missing client certificate
+	request := &tlsClientCertificateRequest{}
+	var peerCertificate *x509.Certificate
 	tlsConfig := &tls.Config{
-		InsecureSkipVerify: true,
+		InsecureSkipVerify:   true,
+		GetClientCertificate: request.getClientCertificate,
+		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			if len(rawCerts) > 0 {
+				peerCertificate, _ = x509.ParseCertificate(rawCerts[0])
+			}
+			return nil
+		},
 	}
 
-	conn, err := tls.Dial("tcp", fmt.Sprintf("%s:%d", hostAddr, port), tlsConfig)
+	tlsConn := tls.Client(conn, tlsConfig)
+	tlsConn.SetDeadline(time.Now().Add(5 * time.Second))
+	err = tlsConn.Handshake()
 	if err != nil {
-		return nil, err
+		// A TLS alert (e.g. a required client certificate) still proves the server speaks TLS
+		if isTlsRemoteAlert(err) {
+			return &TlsSessionDiscoveryResult{
+				isTls:             true,
+				host:              hostAddr,
+				port:              port,
+				evidence:          "server answered the ClientHello with " + err.Error(),
+				peerCertificate:   peerCertificate,
+				clientCertificate: request.outcome(true),
+			}, nil
+		}
+		return &TlsSessionDiscoveryResult{isTls: false, host: hostAddr, port: port, evidence: "TLS handshake failed: " + err.Error()}, nil
 	}
-	defer conn.Close()
 
-	return &TlsSessionDiscoveryResult{isTls: true, host: hostAddr, port: port}, nil
+	// TLS 1.3 completes the client side of the handshake before the server
+	// checks the client certificate, a rejection arrives as the first record
+	alert := false
+	if request.requested {
+		tlsConn.SetReadDeadline(time.Now().Add(tlsClientCertificateAlertTimeout))
+		_, err := tlsConn.Read(make([]byte, 1))
+		alert = isTlsRemoteAlert(err)
+	}
+
+	state := tlsConn.ConnectionState()
+	return &TlsSessionDiscoveryResult{
+		isTls:             true,
+		host:              hostAddr,
+		port:              port,
+		evidence:          "TLS handshake completed with " + tls.VersionName(state.Version),
+		tlsState:          state,
+		peerCertificate:   peerCertificate,
+		clientCertificate: request.outcome(alert),
+	}, nil
 }
 
+// tlsClientCertificateAlertTimeout is how long we wait for a TLS 1.3 server to
+// reject the client certificate after the handshake
+const tlsClientCertificateAlertTimeout = time.Second
+
 func (d *TlsSessionDiscoveryResult) Protocol() SessionLayerProtocol {
 	return TLS
 }
@@ -48,7 +184,29 @@ func (d *TlsSessionDiscoveryResult) GetIsDetected() bool {
 }
 
 func (d *TlsSessionDiscoveryResult) GetProperties() map[string]interface{} {
-	return nil
+	properties := map[string]interface{}{
+		"evidence":          d.evidence,
+		"proxy":             sessionDialer.String(),
+		"clientCertificate": d.clientCertificate,
+	}
+	if d.tlsState.HandshakeComplete {
+		properties["tlsVersion"] = tls.VersionName(d.tlsState.Version)
+		properties["cipherSuite"] = tls.CipherSuiteName(d.tlsState.CipherSuite)
+		properties["alpn"] = d.tlsState.NegotiatedProtocol
+	}
+	if cert := d.peerCertificate; cert != nil {
+		properties["subject"] = cert.Subject.String()
+		properties["issuer"] = cert.Issuer.String()
+		properties["dnsNames"] = cert.DNSNames
+		properties["notAfter"] = cert.NotAfter
+	}
+	return properties
+}
+
+// ClientCertificateRequired tells whether the server closes sessions without
+// a client certificate it accepts, no application protocol can be probed then
+func (d *TlsSessionDiscoveryResult) ClientCertificateRequired() bool {
+	return d.clientCertificate == TLS_CLIENT_CERT_REQUIRED || d.clientCertificate == TLS_CLIENT_CERT_REJECTED
 }
 
 func (d *TlsSessionDiscoveryResult) GetSessionHandler() (iSessionHandler, error) {
@@ -58,18 +216,41 @@ func (d *TlsSessionDiscoveryResult) GetSessionHandler() (iSessionHandler, error)
 func (d *TlsSessionHandler) Connect() error {
 
 	// Create a TLS config with InsecureSkipVerify set
+	d.clientCertificate = tlsClientCertificateRequest{}
 	tlsConfig := &tls.Config{
-		InsecureSkipVerify: true,
+		InsecureSkipVerify:   true,
+		NextProtos:           d.nextProtos,
+		GetClientCertificate: d.clientCertificate.getClientCertificate,
 	}
 
-	conn, err := tls.Dial("tcp", fmt.Sprintf("%s:%d", d.host, d.port), tlsConfig)
+	conn, err := sessionDialer.Dial("tcp", net.JoinHostPort(d.host, strconv.Itoa(d.port)))
 	if err != nil {
 		return err
 	}
-	d.conn = conn
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return d.clientCertificateError(err)
+	}
+	d.conn = tlsConn
 	return nil
 }
 
+// clientCertificateError marks alerts sent after a certificate request as
+// errTlsClientCertificateRequired
+func (d *TlsSessionHandler) clientCertificateError(err error) error {
+	if d.clientCertificate.requested && isTlsRemoteAlert(err) {
+		return fmt.Errorf("%w: %v", errTlsClientCertificateRequired, err)
+	}
+	return err
+}
+
+// ClientCertificateSent tells whether the last Connect presented a client
+// certificate because the server asked for one
+func (d *TlsSessionHandler) ClientCertificateSent() bool {
+	return d.clientCertificate.sent
+}
+
 func (d *TlsSessionHandler) Destory() error {
 	return d.conn.Close()
 }
@@ -79,7 +260,12 @@ func (d *TlsSessionHandler) Write(data []byte) (int, error) {
 }
 
 func (d *TlsSessionHandler) Read(data []byte) (int, error) {
-	return d.conn.Read(data)
+	n, err := d.conn.Read(data)
+	return n, d.clientCertificateError(err)
+}
+
+func (d *TlsSessionHandler) SetReadDeadline(t time.Time) error {
+	return d.conn.SetReadDeadline(t)
 }
 
 func (d *TlsSessionHandler) GetHost() string {
@@ -89,3 +275,41 @@ func (d *TlsSessionHandler) GetHost() string {
 func (d *TlsSessionHandler) GetPort() int {
 	return d.port
 }
+
+// GetAlpn returns the application protocol negotiated by the last Connect
+func (d *TlsSessionHandler) GetAlpn() string {
+	if d.conn == nil {
+		return ""
+	}
+	return d.conn.ConnectionState().NegotiatedProtocol
+}
+
+// GetPeerCertificates returns the certificate chain the server presented on the
+// last Connect, it stays available after Destory
+func (d *TlsSessionHandler) GetPeerCertificates() []*x509.Certificate {
+	if d.conn == nil {
+		return nil
+	}
+	return d.conn.ConnectionState().PeerCertificates
+}
+
+// sessionClientCertificateSent tells whether the server of a TLS session asked
+// for a client certificate and one was presented, false for other sessions
+func sessionClientCertificateSent(sessionHandler iSessionHandler) bool {
+	tlsHandler, ok := unwrapSessionHandler(sessionHandler).(*TlsSessionHandler)
+	return ok && tlsHandler.ClientCertificateSent()
+}
+
+// sessionPeerCertificate returns the serving certificate of a TLS session, nil
+// for other sessions
+func sessionPeerCertificate(sessionHandler iSessionHandler) *x509.Certificate {
+	tlsHandler, ok := unwrapSessionHandler(sessionHandler).(*TlsSessionHandler)
+	if !ok {
+		return nil
+	}
+	certificates := tlsHandler.GetPeerCertificates()
+	if len(certificates) == 0 {
+		return nil
+	}
+	return certificates[0]
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// selfSignedCertificate creates a certificate usable by TLS clients and servers
+func selfSignedCertificate(t *testing.T, commonName string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestTlsClientCertificateOutcome(t *testing.T) {
+	clientCertificate := selfSignedCertificate(t, "scanner")
+	foreignCAs := x509.NewCertPool()
+	foreignCA, _ := x509.ParseCertificate(selfSignedCertificate(t, "cluster-ca").Certificate[0])
+	foreignCAs.AddCert(foreignCA)
+	tests := []struct {
+		name       string
+		clientAuth tls.ClientAuthType
+		maxVersion uint16
+		clientCAs  *x509.CertPool
+		loaded     []tls.Certificate
+		want       string
+	}{
+		{"not requested", tls.NoClientCert, tls.VersionTLS13, nil, nil, TLS_CLIENT_CERT_NOT_REQUESTED},
+		{"optional", tls.VerifyClientCertIfGiven, tls.VersionTLS13, nil, nil, TLS_CLIENT_CERT_REQUESTED},
+		{"optional from another CA", tls.VerifyClientCertIfGiven, tls.VersionTLS13, foreignCAs, []tls.Certificate{clientCertificate}, TLS_CLIENT_CERT_REQUESTED},
+		{"required TLS 1.2", tls.RequireAnyClientCert, tls.VersionTLS12, nil, nil, TLS_CLIENT_CERT_REQUIRED},
+		{"required TLS 1.3", tls.RequireAnyClientCert, tls.VersionTLS13, nil, nil, TLS_CLIENT_CERT_REQUIRED},
+		{"accepted", tls.RequireAnyClientCert, tls.VersionTLS13, nil, []tls.Certificate{clientCertificate}, TLS_CLIENT_CERT_ACCEPTED},
+		{"rejected", tls.RequireAndVerifyClientCert, tls.VersionTLS13, nil, []tls.Certificate{clientCertificate}, TLS_CLIENT_CERT_REJECTED},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			clientCAs := test.clientCAs
+			if clientCAs == nil {
+				clientCAs = x509.NewCertPool()
+			}
+			server.TLS = &tls.Config{ClientAuth: test.clientAuth, MaxVersion: test.maxVersion, ClientCAs: clientCAs}
+			server.StartTLS()
+			defer server.Close()
+			tlsClientCertificates = test.loaded
+			defer func() { tlsClientCertificates = nil }()
+
+			host, portStr, _ := net.SplitHostPort(server.Listener.Addr().String())
+			port, _ := strconv.Atoi(portStr)
+			result, err := (&TlsSessionDiscovery{}).SessionLayerDiscover(host, port)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tlsResult := result.(*TlsSessionDiscoveryResult)
+			if !tlsResult.GetIsDetected() {
+				t.Fatalf("TLS not detected: %s", tlsResult.evidence)
+			}
+			if tlsResult.clientCertificate != test.want {
+				t.Errorf("clientCertificate = %q, want %q", tlsResult.clientCertificate, test.want)
+			}
+			if tlsResult.GetProperties()["subject"] == nil {
+				t.Error("serving certificate was not recorded")
+			}
+
+			// Session handlers report a required certificate as a session outcome
+			handler, _ := tlsResult.GetSessionHandler()
+			err = handler.Connect()
+			if err == nil {
+				_, err = httpRequest(handler, "GET", "/", nil, nil)
+			}
+			if required := errors.Is(err, errTlsClientCertificateRequired); required != tlsResult.ClientCertificateRequired() {
+				t.Errorf("handler error %v, want errTlsClientCertificateRequired=%v", err, tlsResult.ClientCertificateRequired())
+			}
+		})
+	}
+}
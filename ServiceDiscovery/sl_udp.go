@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"time"
+)
+
+// udpMaxDatagramSize is large enough for any datagram a probe expects back
+const udpMaxDatagramSize = 65535
+
+// udpProbeTimeout is how long we wait for an ICMP port unreachable
+const udpProbeTimeout = 2 * time.Second
+
+type UdpSessionDiscovery struct {
+}
+
+type UdpSessionDiscoveryResult struct {
+	host     string
+	port     int
+	evidence string
+}
+
+// UdpSessionHandler is a datagram-style session handler: every Write sends one
+// datagram and every Read returns one datagram
+type UdpSessionHandler struct {
+	host string
+	port int
+	conn net.Conn
+}
+
+func (d *UdpSessionDiscovery) Protocol() TransportProtocol {
+	return UDP
+}
+
+// SessionLayerDiscover sends an empty datagram, a closed port answers with an
+// ICMP port unreachable which surfaces as a refused read. UDP has no handshake so
+// silence only means open or filtered, the application probes decide the rest.
+func (d *UdpSessionDiscovery) SessionLayerDiscover(hostAddr string, port int) (iSessionLayerDiscoveryResult, error) {
+	conn, err := sessionDialer.Dial("udp", net.JoinHostPort(hostAddr, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte{}); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, udpMaxDatagramSize)
+	conn.SetReadDeadline(time.Now().Add(udpProbeTimeout))
+	_, err = conn.Read(buf)
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return &UdpSessionDiscoveryResult{host: hostAddr, port: port, evidence: "port answered an empty datagram"}, nil
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return &UdpSessionDiscoveryResult{host: hostAddr, port: port, evidence: "no ICMP port unreachable, port is open or filtered"}, nil
+	}
+	return nil, err
+}
+
+func (d *UdpSessionDiscoveryResult) Protocol() SessionLayerProtocol {
+	return NO_SESSION_LAYER
+}
+
+func (d *UdpSessionDiscoveryResult) GetIsDetected() bool {
+	return true
+}
+
+func (d *UdpSessionDiscoveryResult) GetProperties() map[string]interface{} {
+	return map[string]interface{}{
+		"evidence": d.evidence,
+		"proxy":    sessionDialer.String(),
+	}
+}
+
+func (d *UdpSessionDiscoveryResult) GetSessionHandler() (iSessionHandler, error) {
+	return &UdpSessionHandler{host: d.host, port: d.port}, nil
+}
+
+func (d *UdpSessionHandler) Connect() error {
+	conn, err := sessionDialer.Dial("udp", net.JoinHostPort(d.host, strconv.Itoa(d.port)))
+	if err != nil {
+		return err
+	}
+	d.conn = conn
+	return nil
+}
+
+func (d *UdpSessionHandler) Destory() error {
+	return d.conn.Close()
+}
+
+func (d *UdpSessionHandler) Write(data []byte) (int, error) {
+	return d.conn.Write(data)
+}
+
+func (d *UdpSessionHandler) Read(data []byte) (int, error) {
+	return d.conn.Read(data)
+}
+
+func (d *UdpSessionHandler) SetReadDeadline(t time.Time) error {
+	return d.conn.SetReadDeadline(t)
+}
+
+func (d *UdpSessionHandler) GetHost() string {
+	return d.host
+}
+
+func (d *UdpSessionHandler) GetPort() int {
+	return d.port
+}
+
+func (d *UdpSessionHandler) GetMaxDatagramSize() int {
+	return udpMaxDatagramSize
+}
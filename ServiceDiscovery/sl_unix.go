@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// UNIX_TARGET_PREFIX marks a target which is a Unix domain socket path
+const UNIX_TARGET_PREFIX = "unix://"
+
+type UnixSessionDiscovery struct {
+}
+
+type UnixSessionDiscoveryResult struct {
+	path string
+	mode os.FileMode
+}
+
+// UnixSessionHandler is a stream session handler over a Unix domain socket,
+// GetHost returns the socket path and GetPort is always 0
+type UnixSessionHandler struct {
+	path string
+	conn net.Conn
+}
+
+func (d *UnixSessionDiscovery) Protocol() TransportProtocol {
+	return UNIX
+}
+
+// SessionLayerDiscover checks whether the socket can be connected to from the
+// current security context (uid, gid and capabilities of this process)
+func (d *UnixSessionDiscovery) SessionLayerDiscover(hostAddr string, port int) (iSessionLayerDiscoveryResult, error) {
+	path := hostAddr
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return nil, fmt.Errorf("%s is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			return nil, fmt.Errorf("%s (%s) is not reachable by uid %d gid %d: %v", path, info.Mode(), os.Geteuid(), os.Getegid(), err)
+		}
+		return nil, err
+	}
+	conn.Close()
+
+	return &UnixSessionDiscoveryResult{path: path, mode: info.Mode()}, nil
+}
+
+func (d *UnixSessionDiscoveryResult) Protocol() SessionLayerProtocol {
+	return NO_SESSION_LAYER
+}
+
+func (d *UnixSessionDiscoveryResult) GetIsDetected() bool {
+	return true
+}
+
+func (d *UnixSessionDiscoveryResult) GetProperties() map[string]interface{} {
+	return map[string]interface{}{
+		"evidence":  fmt.Sprintf("socket is reachable by uid %d gid %d", os.Geteuid(), os.Getegid()),
+		"path":      d.path,
+		"mode":      d.mode.String(),
+		"reachable": true,
+	}
+}
+
+func (d *UnixSessionDiscoveryResult) GetSessionHandler() (iSessionHandler, error) {
+	return &UnixSessionHandler{path: d.path}, nil
+}
+
+func (d *UnixSessionHandler) Connect() error {
+	conn, err := net.DialTimeout("unix", d.path, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	d.conn = conn
+	return nil
+}
+
+func (d *UnixSessionHandler) Destory() error {
+	return d.conn.Close()
+}
+
+func (d *UnixSessionHandler) Write(data []byte) (int, error) {
+	return d.conn.Write(data)
+}
+
+func (d *UnixSessionHandler) Read(data []byte) (int, error) {
+	return d.conn.Read(data)
+}
+
+func (d *UnixSessionHandler) SetReadDeadline(t time.Time) error {
+	return d.conn.SetReadDeadline(t)
+}
+
+func (d *UnixSessionHandler) GetHost() string {
+	return d.path
+}
+
+func (d *UnixSessionHandler) GetPort() int {
+	return 0
+}
@@ -1,5 +1,7 @@
 package main
 
+import "time"
+
 type TransportProtocol string
 type PresentationLayerProtocol string
 type SessionLayerProtocol string
@@ -7,10 +9,26 @@ type SessionLayerProtocol string
 const (
 	TCP              TransportProtocol         = "tcp"
 	UDP              TransportProtocol         = "udp"
+	UNIX             TransportProtocol         = "unix"
 	TLS              SessionLayerProtocol      = "tls"
+	STARTTLS         SessionLayerProtocol      = "starttls"
+	DTLS             SessionLayerProtocol      = "dtls"
+	QUIC             SessionLayerProtocol      = "quic"
 	SSH              SessionLayerProtocol      = "ssh"
 	NO_SESSION_LAYER SessionLayerProtocol      = "no_session_layer"
 	HTTP             PresentationLayerProtocol = "http"
+	HTTP2            PresentationLayerProtocol = "http2"
+	HTTP3            PresentationLayerProtocol = "http3"
+	GRPC             PresentationLayerProtocol = "grpc"
+)
+
+// ProbeOrder declares whether a detector reads what the server sends first or
+// sends a request itself. Server-first detectors run before any data is written.
+type ProbeOrder string
+
+const (
+	SERVER_FIRST ProbeOrder = "server_first"
+	CLIENT_FIRST ProbeOrder = "client_first"
 )
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -22,10 +40,18 @@ type iSessionHandler interface {
 	Destory() error
 	Write([]byte) (int, error)
 	Read([]byte) (int, error)
+	SetReadDeadline(time.Time) error
 	GetHost() string
 	GetPort() int
 }
 
+// iDatagramSessionHandler is implemented by session handlers over UDP, every
+// Write sends one datagram and every Read returns one datagram
+type iDatagramSessionHandler interface {
+	iSessionHandler
+	GetMaxDatagramSize() int
+}
+
 type iSessionLayerDiscoveryResult interface {
 	Protocol() SessionLayerProtocol
 	GetIsDetected() bool
@@ -50,6 +76,7 @@ type iPresentationDiscoveryResult interface {
 
 type PresentationLayerDiscovery interface {
 	Protocol() PresentationLayerProtocol
+	ProbeOrder() ProbeOrder
 	Discover(sessionHandler iSessionHandler) (iPresentationDiscoveryResult, error)
 }
 
@@ -66,5 +93,25 @@ type iApplicationDiscoveryResult interface {
 
 type ApplicationLayerDiscovery interface {
 	Protocol() string
+	ProbeOrder() ProbeOrder
 	Discover(sessionHandler iSessionHandler, presenationLayerDiscoveryResult iPresentationDiscoveryResult) (iApplicationDiscoveryResult, error)
 }
+
+// FindingSeverity ranks a finding, from critical down to informational
+type FindingSeverity string
+
+const (
+	SEVERITY_CRITICAL FindingSeverity = "critical"
+	SEVERITY_HIGH     FindingSeverity = "high"
+	SEVERITY_MEDIUM   FindingSeverity = "medium"
+	SEVERITY_LOW      FindingSeverity = "low"
+	SEVERITY_INFO     FindingSeverity = "info"
+)
+
+// Finding is a security issue of a detected service. Detectors list them in
+// the "findings" property of their result.
+type Finding struct {
+	Severity FindingSeverity
+	Title    string
+	Detail   string
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// osvVulnerability is the part of an OSV entry (https://ossf.github.io/osv-schema/)
+// used for matching product versions
+type osvVulnerability struct {
+	Id       string   `json:"id"`
+	Aliases  []string `json:"aliases"`
+	Summary  string   `json:"summary"`
+	Severity []struct {
+		Type  string `json:"type"`
+		Score string `json:"score"`
+	} `json:"severity"`
+	Affected []struct {
+		Package struct {
+			Ecosystem string `json:"ecosystem"`
+			Name      string `json:"name"`
+		} `json:"package"`
+		Ranges []struct {
+			Type   string              `json:"type"`
+			Events []map[string]string `json:"events"`
+		} `json:"ranges"`
+		Versions          []string `json:"versions"`
+		EcosystemSpecific struct {
+			Severity string `json:"severity"`
+		} `json:"ecosystem_specific"`
+	} `json:"affected"`
+	DatabaseSpecific struct {
+		Severity string `json:"severity"`
+	} `json:"database_specific"`
+}
+
+// osvDatabase holds the entries loaded with LoadOsvDatabase, nothing is matched
+// while it is empty
+var osvDatabase []osvVulnerability
+
+// osvPackage names a package of an OSV ecosystem
+type osvPackage struct {
+	ecosystem string
+	name      string
+}
+
+// osvProductPackages maps the normalized product names of the detectors to the
+// packages their vulnerabilities are published under. Products without an
+// entry only match packages of the same name, which suits custom databases.
+// MySQL, PostgreSQL and Redis have no upstream ecosystem on osv.dev, their
+// advisories are only published per distribution package with backported
+// versions, so they are matched by custom databases naming "mysql",
+// "postgresql" or "redis" alone.
+var osvProductPackages = map[string][]osvPackage{
+	"kube-apiserver":          {{"Go", "k8s.io/kubernetes"}, {"Go", "k8s.io/apiserver"}},
+	"kubelet":                 {{"Go", "k8s.io/kubernetes"}},
+	"kube-proxy":              {{"Go", "k8s.io/kubernetes"}},
+	"kube-scheduler":          {{"Go", "k8s.io/kubernetes"}},
+	"kube-controller-manager": {{"Go", "k8s.io/kubernetes"}},
+	"etcd":                    {{"Go", "go.etcd.io/etcd/server/v3"}, {"Go", "go.etcd.io/etcd/v3"}, {"Go", "go.etcd.io/etcd"}},
+	"docker":                  {{"Go", "github.com/docker/docker"}, {"Go", "github.com/moby/moby"}},
+	"cri-o":                   {{"Go", "github.com/cri-o/cri-o"}},
+	"containerd":              {{"Go", "github.com/containerd/containerd"}},
+	"coredns":                 {{"Go", "github.com/coredns/coredns"}},
+	"prometheus":              {{"Go", "github.com/prometheus/prometheus"}},
+	"grafana":                 {{"Go", "github.com/grafana/grafana"}},
+	"argo-cd":                 {{"Go", "github.com/argoproj/argo-cd/v2"}, {"Go", "github.com/argoproj/argo-cd"}},
+	"cilium":                  {{"Go", "github.com/cilium/cilium"}},
+	"traefik":                 {{"Go", "github.com/traefik/traefik/v2"}, {"Go", "github.com/traefik/traefik/v3"}},
+	"ingress-nginx":           {{"Go", "k8s.io/ingress-nginx"}},
+	"jenkins":                 {{"Maven", "org.jenkins-ci.main:jenkins-core"}},
+}
+
+// osvProductAliases maps detector protocol names to product names
+var osvProductAliases = map[string]string{
+	"my-sql":           "mysql",
+	"kubelet-readonly": "kubelet",
+	"postgres":         "postgresql",
+}
+
+// osvVersionRegexp finds the dotted release number in version strings such as
+// "v1.28.3+k3s1", "8.0.34-0ubuntu0.22.04.1" or "PostgreSQL 15.4 (Debian ...)"
+var osvVersionRegexp = regexp.MustCompile(`\d+(\.\d+)+`)
+
+// LoadOsvDatabase loads OSV entries from a JSON file holding one entry or an
+// array of entries, or from every .json file of a directory such as an
+// unpacked osv.dev ecosystem export
+func LoadOsvDatabase(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return loadOsvFile(path)
+	}
+	return filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(file, ".json") {
+			return err
+		}
+		return loadOsvFile(file)
+	})
+}
+
+func loadOsvFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var entries []osvVulnerability
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		err = json.Unmarshal(data, &entries)
+	} else {
+		var entry osvVulnerability
+		err = json.Unmarshal(data, &entry)
+		entries = append(entries, entry)
+	}
+	if err != nil {
+		return err
+	}
+	osvDatabase = append(osvDatabase, entries...)
+	return nil
+}
+
+// normalizeProduct returns the product and release version of a detected
+// service, the version is empty when the result does not report one
+func normalizeProduct(result iApplicationDiscoveryResult) (string, string) {
+	properties := result.GetProperties()
+	product := strings.ToLower(result.Protocol())
+	if component, ok := properties["component"].(string); ok && component != "" {
+		// Metrics endpoints name the component exposing them
+		product = strings.ToLower(component)
+	}
+	if alias, ok := osvProductAliases[product]; ok {
+		product = alias
+	}
+
+	version := ""
+	for _, key := range []string{"version", "ServerVersion"} {
+		// MySQL reports the ServerVersion of its handshake packet as bytes
+		switch value := properties[key].(type) {
+		case string:
+			version = value
+		case []byte:
+			version = string(value)
+		}
+		if version != "" {
+			break
+		}
+	}
+	return product, osvVersionRegexp.FindString(version)
+}
+
+// matchVulnerabilities returns the OSV entries affecting the product version
+// together with the versions fixing them
+func matchVulnerabilities(product string, version string) ([]*osvVulnerability, [][]string) {
+	packages, known := osvProductPackages[product]
+	var matched []*osvVulnerability
+	var fixedVersions [][]string
+	for i := range osvDatabase {
+		entry := &osvDatabase[i]
+		for _, affected := range entry.Affected {
+			if !osvPackageMatches(affected.Package.Ecosystem, affected.Package.Name, product, packages, known) {
+				continue
+			}
+			affectedVersion := false
+			for _, listed := range affected.Versions {
+				if listed := osvVersionRegexp.FindString(listed); listed != "" && compareVersions(listed, version) == 0 {
+					affectedVersion = true
+				}
+			}
+			var fixed []string
+			for _, versionRange := range affected.Ranges {
+				if versionRange.Type == "GIT" {
+					continue
+				}
+				affectedVersion = affectedVersion || osvRangeAffects(versionRange.Events, version)
+				for _, event := range versionRange.Events {
+					if event["fixed"] != "" {
+						fixed = append(fixed, event["fixed"])
+					}
+				}
+			}
+			if affectedVersion {
+				matched = append(matched, entry)
+				fixedVersions = append(fixedVersions, fixed)
+				break
+			}
+		}
+	}
+	return matched, fixedVersions
+}
+
+func osvPackageMatches(ecosystem string, name string, product string, packages []osvPackage, known bool) bool {
+	if !known {
+		return strings.EqualFold(name, product)
+	}
+	for _, candidate := range packages {
+		if candidate.ecosystem == ecosystem && candidate.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// osvRangeAffects evaluates the events of a SEMVER or ECOSYSTEM range in
+// version order as described by the OSV schema
+func osvRangeAffects(events []map[string]string, version string) bool {
+	type rangeEvent struct {
+		kind    string
+		version string
+	}
+	var sorted []rangeEvent
+	for _, event := range events {
+		for kind, value := range event {
+			sorted = append(sorted, rangeEvent{kind, strings.TrimPrefix(value, "v")})
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return compareVersions(sorted[i].version, sorted[j].version) < 0
+	})
+
+	affected := false
+	for _, event := range sorted {
+		switch event.kind {
+		case "introduced":
+			if event.version == "0" || compareVersions(version, event.version) >= 0 {
+				affected = true
+			}
+		case "fixed":
+			if compareVersions(version, event.version) >= 0 {
+				affected = false
+			}
+		case "last_affected":
+			if compareVersions(version, event.version) > 0 {
+				affected = false
+			}
+		}
+	}
+	return affected
+}
+
+// compareVersions compares dotted versions segment by segment, numerically
+// where both segments are numbers. Pre-release and build suffixes are ignored.
+func compareVersions(a string, b string) int {
+	segmentsA := strings.Split(osvVersionCore(a), ".")
+	segmentsB := strings.Split(osvVersionCore(b), ".")
+	for i := 0; i < len(segmentsA) || i < len(segmentsB); i++ {
+		segmentA, segmentB := "0", "0"
+		if i < len(segmentsA) {
+			segmentA = segmentsA[i]
+		}
+		if i < len(segmentsB) {
+			segmentB = segmentsB[i]
+		}
+		numberA, errA := strconv.Atoi(segmentA)
+		numberB, errB := strconv.Atoi(segmentB)
+		switch {
+		case errA == nil && errB == nil && numberA != numberB:
+			if numberA < numberB {
+				return -1
+			}
+			return 1
+		case (errA != nil || errB != nil) && segmentA != segmentB:
+			return strings.Compare(segmentA, segmentB)
+		}
+	}
+	return 0
+}
+
+func osvVersionCore(version string) string {
+	version = strings.TrimPrefix(version, "v")
+	if end := strings.IndexAny(version, "-+ "); end >= 0 {
+		version = version[:end]
+	}
+	return version
+}
+
+// osvSeverity rates an entry from its database or ecosystem specific severity,
+// e.g. GHSA "CRITICAL" or "MODERATE". Unrated entries are reported as medium.
+func osvSeverity(entry *osvVulnerability) FindingSeverity {
+	rating := entry.DatabaseSpecific.Severity
+	for _, affected := range entry.Affected {
+		if rating == "" {
+			rating = affected.EcosystemSpecific.Severity
+		}
+	}
+	switch strings.ToUpper(rating) {
+	case "CRITICAL":
+		return SEVERITY_CRITICAL
+	case "HIGH":
+		return SEVERITY_HIGH
+	case "LOW":
+		return SEVERITY_LOW
+	}
+	return SEVERITY_MEDIUM
+}
+
+// osvIds returns the CVE and GHSA identifiers of an entry, CVEs first
+func osvIds(entry *osvVulnerability) []string {
+	var cves, others []string
+	for _, id := range append([]string{entry.Id}, entry.Aliases...) {
+		if strings.HasPrefix(id, "CVE-") {
+			cves = append(cves, id)
+		} else {
+			others = append(others, id)
+		}
+	}
+	return append(cves, others...)
+}
+
+// addVulnerabilityFindings matches the product version of a detected service
+// against the OSV database and appends a finding per affecting entry to the
+// "findings" property, the matches are listed in the "vulnerabilities" property
+func addVulnerabilityFindings(result iApplicationDiscoveryResult) {
+	properties := result.GetProperties()
+	if len(osvDatabase) == 0 || properties == nil {
+		return
+	}
+	product, version := normalizeProduct(result)
+	if version == "" {
+		return
+	}
+
+	entries, fixedVersions := matchVulnerabilities(product, version)
+	if len(entries) == 0 {
+		return
+	}
+	findings, _ := properties["findings"].([]Finding)
+	var vulnerabilities []map[string]interface{}
+	for i, entry := range entries {
+		ids := osvIds(entry)
+		severity := osvSeverity(entry)
+		vulnerability := map[string]interface{}{
+			"ids":      ids,
+			"severity": string(severity),
+			"summary":  entry.Summary,
+			"fixed":    fixedVersions[i],
+		}
+		var scores []string
+		for _, score := range entry.Severity {
+			scores = append(scores, score.Score)
+		}
+		if len(scores) > 0 {
+			vulnerability["cvss"] = scores
+		}
+		vulnerabilities = append(vulnerabilities, vulnerability)
+
+		detail := entry.Summary
+		if len(fixedVersions[i]) > 0 {
+			detail += ", fixed in " + strings.Join(fixedVersions[i], ", ")
+		}
+		findings = append(findings, Finding{
+			Severity: severity,
+			Title:    product + " " + version + " is affected by " + strings.Join(ids, ", "),
+			Detail:   detail,
+		})
+	}
+	properties["vulnerabilities"] = vulnerabilities
+	properties["findings"] = findings
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.28.3", "1.28.3", 0},
+		{"1.28", "1.28.0", 0},
+		{"1.9.0", "1.10.0", -1},
+		{"1.28.10", "1.28.9", 1},
+		{"v1.28.3", "1.28.3", 0},
+		{"1.28.3+k3s1", "1.28.3", 0},
+		{"1.28.3-rc.1", "1.28.3", 0},
+		{"0", "0.0.1", -1},
+	}
+	for _, test := range tests {
+		if got := compareVersions(test.a, test.b); got != test.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestOsvRangeAffects(t *testing.T) {
+	events := func(pairs ...string) []map[string]string {
+		var result []map[string]string
+		for i := 0; i < len(pairs); i += 2 {
+			result = append(result, map[string]string{pairs[i]: pairs[i+1]})
+		}
+		return result
+	}
+	tests := []struct {
+		name    string
+		events  []map[string]string
+		version string
+		want    bool
+	}{
+		{"introduced zero", events("introduced", "0", "fixed", "1.2.0"), "0.1.0", true},
+		{"introduced zero fixed", events("introduced", "0", "fixed", "1.2.0"), "1.2.0", false},
+		{"introduced zero open", events("introduced", "0"), "99.0.0", true},
+		{"before introduced", events("introduced", "1.5.0", "fixed", "1.5.4"), "1.4.9", false},
+		{"at introduced", events("introduced", "1.5.0", "fixed", "1.5.4"), "1.5.0", true},
+		{"first of two pairs", events("introduced", "1.26.0", "fixed", "1.26.11", "introduced", "1.27.0", "fixed", "1.27.8"), "1.26.10", true},
+		{"between two pairs", events("introduced", "1.26.0", "fixed", "1.26.11", "introduced", "1.27.0", "fixed", "1.27.8"), "1.26.12", false},
+		{"second of two pairs", events("introduced", "1.26.0", "fixed", "1.26.11", "introduced", "1.27.0", "fixed", "1.27.8"), "1.27.3", true},
+		{"after two pairs", events("introduced", "1.26.0", "fixed", "1.26.11", "introduced", "1.27.0", "fixed", "1.27.8"), "1.28.0", false},
+		{"pairs out of order", events("introduced", "1.27.0", "fixed", "1.27.8", "introduced", "1.26.0", "fixed", "1.26.11"), "1.26.5", true},
+		{"at last_affected", events("introduced", "3.5.0", "last_affected", "3.5.9"), "3.5.9", true},
+		{"after last_affected", events("introduced", "3.5.0", "last_affected", "3.5.9"), "3.5.10", false},
+		{"v-prefixed events", events("introduced", "v1.28.0", "fixed", "v1.28.4"), "1.28.3", true},
+		{"v-prefixed fixed", events("introduced", "v1.28.0", "fixed", "v1.28.4"), "1.28.4", false},
+		{"numeric segments", events("introduced", "1.9.0", "fixed", "1.10.2"), "1.10.1", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := osvRangeAffects(test.events, test.version); got != test.want {
+				t.Errorf("osvRangeAffects(%v, %q) = %v, want %v", test.events, test.version, got, test.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeProduct(t *testing.T) {
+	tests := []struct {
+		name        string
+		result      iApplicationDiscoveryResult
+		wantProduct string
+		wantVersion string
+	}{
+		{
+			name:        "k3s build of a metrics component",
+			result:      &PrometheusMetricsDiscoveryResult{properties: map[string]interface{}{"component": "kubelet", "version": "v1.28.3+k3s1"}},
+			wantProduct: "kubelet",
+			wantVersion: "1.28.3",
+		},
+		{
+			name:        "distribution suffix",
+			result:      &PrometheusMetricsDiscoveryResult{properties: map[string]interface{}{"component": "etcd", "version": "3.5.9-0ubuntu1"}},
+			wantProduct: "etcd",
+			wantVersion: "3.5.9",
+		},
+		{
+			name:        "no component",
+			result:      &PrometheusMetricsDiscoveryResult{properties: map[string]interface{}{"version": "2.48.0"}},
+			wantProduct: "prometheus-metrics",
+			wantVersion: "2.48.0",
+		},
+		{
+			name:        "MySQL handshake version",
+			result:      &MysqlDiscoveryResult{IsDetected: true, properties: map[string]interface{}{"ServerVersion": []byte("8.0.34-0ubuntu0.22.04.1")}},
+			wantProduct: "mysql",
+			wantVersion: "8.0.34",
+		},
+		{
+			name:        "no version",
+			result:      &PrometheusMetricsDiscoveryResult{properties: map[string]interface{}{"component": "coredns"}},
+			wantProduct: "coredns",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			product, version := normalizeProduct(test.result)
+			if product != test.wantProduct || version != test.wantVersion {
+				t.Errorf("normalizeProduct() = %q, %q, want %q, %q", product, version, test.wantProduct, test.wantVersion)
+			}
+		})
+	}
+}
+
+func TestMatchVulnerabilities(t *testing.T) {
+	database := `[
+		{"id": "GHSA-kube-0001", "aliases": ["CVE-2023-0001"], "affected": [
+			{"package": {"ecosystem": "Go", "name": "k8s.io/kubernetes"}, "ranges": [
+				{"type": "SEMVER", "events": [{"introduced": "0"}, {"fixed": "1.27.8"}, {"introduced": "1.28.0"}, {"fixed": "1.28.4"}]}
+			]}
+		]},
+		{"id": "GHSA-kube-0002", "affected": [
+			{"package": {"ecosystem": "Go", "name": "k8s.io/kubernetes"}, "ranges": [
+				{"type": "SEMVER", "events": [{"introduced": "v1.29.0"}, {"last_affected": "v1.29.1"}]}
+			]}
+		]},
+		{"id": "GHSA-etcd-0001", "affected": [
+			{"package": {"ecosystem": "Go", "name": "go.etcd.io/etcd/server/v3"}, "ranges": [
+				{"type": "SEMVER", "events": [{"introduced": "0"}, {"fixed": "3.5.10"}]}
+			]}
+		]},
+		{"id": "CUSTOM-mysql-0001", "affected": [
+			{"package": {"ecosystem": "custom", "name": "mysql"}, "ranges": [
+				{"type": "ECOSYSTEM", "events": [{"introduced": "8.0.0"}, {"fixed": "8.0.36"}]}
+			]}
+		]}
+	]`
+	saved := osvDatabase
+	defer func() { osvDatabase = saved }()
+	osvDatabase = nil
+	if err := json.Unmarshal([]byte(database), &osvDatabase); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		product string
+		version string
+		want    []string
+	}{
+		{"kubelet", "1.28.3", []string{"GHSA-kube-0001"}},
+		{"kube-apiserver", "1.27.5", []string{"GHSA-kube-0001"}},
+		{"kubelet", "1.28.4", nil},
+		{"kubelet", "1.29.1", []string{"GHSA-kube-0002"}},
+		{"kubelet", "1.29.2", nil},
+		{"etcd", "3.5.9", []string{"GHSA-etcd-0001"}},
+		{"coredns", "1.11.1", nil},
+		{"mysql", "8.0.34", []string{"CUSTOM-mysql-0001"}},
+		{"mysql", "8.0.36", nil},
+	}
+	for _, test := range tests {
+		entries, _ := matchVulnerabilities(test.product, test.version)
+		var ids []string
+		for _, entry := range entries {
+			ids = append(ids, entry.Id)
+		}
+		if !reflect.DeepEqual(ids, test.want) {
+			t.Errorf("matchVulnerabilities(%q, %q) = %v, want %v", test.product, test.version, ids, test.want)
+		}
+	}
+}
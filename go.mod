@@ -0,0 +1,19 @@
+module kubescanner
+
+go 1.23
+
+require (
+	github.com/lib/pq v1.9.0
+	github.com/pion/dtls/v2 v2.2.12
+	github.com/quic-go/quic-go v0.54.0
+	golang.org/x/net v0.28.0
+)
+
+require (
+	github.com/pion/logging v0.2.2 // indirect
+	github.com/pion/transport/v2 v2.2.10 // indirect
+	github.com/quic-go/qpack v0.5.1 // indirect
+	golang.org/x/crypto v0.26.0 // indirect
+	golang.org/x/sys v0.23.0 // indirect
+	golang.org/x/text v0.17.0 // indirect
+)